@@ -21,32 +21,70 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"io"
+	"io/fs"
 	"log"
+	"math"
+	"math/bits"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 const (
 	// program information
 	PROG    = "section"
-	VERSION = "0.10.0"
+	VERSION = "0.10.0+"
+	// configuration
+	CONFIG_NAME = "config"
+	ENV_OPTIONS = "SECTION_OPTIONS"
 	// technical peculiarities
-	ARB_BUF_LIM = 512 * 1024 * 1024 // 512MiB
+	DECODE_BUF_SIZE = 4 * 1024  // 4KiB
+	FOLLOW_BUF_SIZE = 64 * 1024 // 64KiB
+	FOLLOW_POLL     = 250 * time.Millisecond
 	// internal regular expressions
 	YAML_IND_RE = `^[ \t]*(- )*`
 	BLANK_RE    = `^[ \t]*$`
 	RE_IGN_CASE = `(?i)`
+	// characters not needing quotes in shell words
+	SHELL_SAFE = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"0123456789%+,-./:=@_"
 	// default values
+	DEF_BINARY_FILES          = "binary"
+	DEF_ENCODING              = "auto"
 	DEF_FILE_HEADER_PREFIX    = "==> "
 	DEF_FILE_HEADER_SUFFIX    = " <=="
 	DEF_FILE_SEPARATOR_STRING = "%%"
+	DEF_FLATTEN_SEPARATOR     = " > "
+	DEF_IDLE_TIMEOUT          = time.Second
 	DEF_IND_RE                = `^[ \t]*`
+	DEF_IND_RE_UNICODE        = `^[\t\p{Zs}]*`
+	DEF_INDENT_WIDTH          = "bytes"
+	DEF_INPUT                 = "text"
+	DEF_LINE_ENDINGS          = "lf"
+	DEF_LONG_LINES            = "error"
+	DEF_MERGE_STRATEGY        = "recursive-merge"
+	DEF_OUTPUT                = "text"
 	DEF_PREFIX_DELIM          = ":"
 	DEF_SEPARATOR             = "--"
+	DEF_SORT_SECTIONS         = "lexical"
 	DEF_STDIN_LABEL           = "(standard input)"
 	// documentation
 	DESC      = "prints indented text sections selected by matching a pattern."
@@ -55,62 +93,237 @@ License GPLv3+: GNU GPL version 3 or later <https://gnu.org/licenses/gpl.html>.
 This is free software: you are free to change and redistribute it.
 There is NO WARRANTY, to the extent permitted by law.`
 	OD_BEGIN                 = "also select all lines following first matched section"
+	OD_BINARY_FILES          = "handling of binary input: binary, text, or without-match"
+	OD_COLUMN                = "table column NAME=REGEX, value from first submatch"
+	OD_CONFIG                = "read default options and profiles from given file"
+	OD_CONTAINS              = "select only sections containing a line matching regexp"
+	OD_CONTAINS_IP_EQUALS    = "select only sections containing the IP address or prefix"
+	OD_CONTAINS_IP_IN        = "select only sections containing an IP address inside prefix"
+	OD_COMMENT_RE            = "continue sections over comment lines matching regexp or preset"
 	OD_ENCLOSING             = "select sections enclosing matched lines"
+	OD_ENCODING              = "character encoding of the input, default from byte order mark"
 	OD_FILE_HEADER           = "print file header before each file with output"
 	OD_FILE_HEADER_PREFIX    = "specify file header prefix"
 	OD_FILE_HEADER_SUFFIX    = "specify file header suffix"
 	OD_FILE_SEPARATOR        = "print a separator line between files"
 	OD_FILE_SEPARATOR_STRING = "specify file separator string"
 	OD_FIXED_STRING          = "PATTERN is fixed string, not regular expression"
+	OD_FLATTEN               = "prefix lines with their ancestor lines"
+	OD_FLATTEN_SEPARATOR     = "separator between ancestor lines for --flatten and --unflatten"
+	OD_FOLLOW                = "keep reading FILE when it grows, similar to tail -F"
+	OD_FOLLOWING_SIBLINGS    = "also select up to N following sibling sections"
+	OD_FORMAT                = "template for printed lines"
 	OD_HEADERS               = "also select headers of selected sections"
 	OD_HELP                  = "display help text and exit"
 	OD_IGNORE_BLANK          = "continue sections over blank lines"
 	OD_IGNORE_CASE           = "ignore case distinctions"
 	OD_IGNORE_PREFIX         = "ignore prefix matching regexp for indentation depth determination"
+	OD_IDLE_TIMEOUT          = "with --follow, print pending lines after this time without input"
 	OD_IGNORE_RE             = "continue sections over lines matching regexp"
 	OD_INDENT_RE             = "regular expression defining indentation"
+	OD_INDENT_WIDTH          = "unit of indentation width: bytes, runes, or columns"
 	OD_INVERT_MATCH          = "match sections not starting with PATTERN"
+	OD_INPUT                 = "input format: text, or set for Junos set commands"
+	OD_INTERFACE             = "match interface configuration lines covering NAME instead of PATTERN"
+	OD_IP_EQUALS             = "match lines containing the IP address or prefix instead of PATTERN"
+	OD_IP_IN                 = "match lines containing an IP address inside prefix instead of PATTERN"
+	OD_JOBS                  = "process up to N files concurrently (0: number of CPUs)"
+	OD_KEY_PATH              = "select JSON or YAML nodes by key path instead of PATTERN"
+	OD_LABEL_SECTION         = "prefix lines of sections with template using PATTERN submatches"
+	OD_LINE_ENDINGS          = "output line terminators: lf, crlf, or preserve"
 	OD_LINE_NUMBER           = "prefix output lines with line number"
+	OD_LONG_LINES            = "handling of lines exceeding --max-line-length: error, skip, or truncate"
+	OD_LIST_PROFILES         = "list available profiles with their options and exit"
+	OD_MAX_BUFFER            = "maximum size of memorized lines, with optional K, M, or G suffix"
+	OD_MAX_LINE_LENGTH       = "maximum length of an input line, with optional K, M, or G suffix"
+	OD_MERGE                 = "print the first FILE merged with the other files instead of sections"
+	OD_MERGE_STRATEGY        = "--merge strategy: replace, append-children, or recursive-merge"
+	OD_NO_CONFIG             = "ignore configuration file and environment variable"
+	OD_NO_MATCH_COMMENTS     = "do not match PATTERN inside comment lines"
+	OD_NOT_CONTAINS          = "select only sections not containing a line matching regexp"
 	OD_OMIT                  = "omit (exclude) matched sections, print everything else"
 	OD_OMIT_IGNORED          = "omit lines ignored as section breaks"
+	OD_OUTPUT                = "output format: text, set for Junos set commands, html, or markdown"
+	OD_PARENT                = "select sections N levels above matched lines"
 	OD_PREFIX_DELIM          = "string to delimit a prefix"
+	OD_PROFILE               = "use option settings of named profile"
 	OD_QUIET                 = "suppress all normal output"
+	OD_REENCODE              = "use the character encoding of the input for the output"
+	OD_SECTION_FORMAT        = "template for lines printed before and after each section"
 	OD_SEPARATOR             = "print a separator line between sections"
 	OD_SEPARATOR_STRING      = "specify section separator string"
+	OD_SIBLINGS              = "also select all sibling sections"
+	OD_SORT_DEPTH            = "nesting depth of sections to sort or deduplicate"
+	OD_SORT_KEY              = "regexp selecting the sort key from a section header"
+	OD_SORT_SECTIONS         = "sort sections: lexical, natural, or numeric"
 	OD_STDIN_LABEL           = "label in place of file name for standard input"
 	OD_TAB_IS_N_SPACES       = "treat tab as a fixed number of space characters"
 	OD_TAB_SIZE              = "number of characters between two tab stops"
+	OD_TABLE                 = "print a table row per section: csv or tsv"
+	OD_TEXT                  = "process binary input as text, like --binary-files text"
 	OD_TOP_LEVEL             = "sections start from minimum indentation level"
+	OD_UNFLATTEN             = "rebuild indented text from lines prefixed with their ancestors"
+	OD_UNIQ_SECTIONS         = "drop sections identical to a previous section at --sort-depth"
 	OD_WITH_FILENAME         = "prefix output lines with file name"
+	OD_YAML                  = "determine sections from YAML structure"
 	OD_YAML_IND              = "additionally allow YAML list indentation"
 	OD_VERSION               = "display version and exit"
 )
 
+// comment line presets usable as argument to --comment-re
+var comment_presets = map[string]string{
+	"#":  `^[ \t]*#`,
+	"//": `^[ \t]*//`,
+	"!":  `^[ \t]*!`,
+	";":  `^[ \t]*;`,
+}
+
+// one option setting as part of a profile
+type profile_setting struct {
+	name  string // option name without leading hyphens
+	value string // option value, "true" for boolean options
+}
+
+// a named collection of option settings
+type profile struct {
+	name     string
+	settings []profile_setting
+}
+
+// built-in profiles usable as argument to --profile
+var profiles = []profile{
+	{"ansible", []profile_setting{
+		{"yaml", "true"},
+	}},
+	{"eos", []profile_setting{
+		{"comment-re", "!"},
+		{"ignore-blank", "true"},
+	}},
+	{"frr", []profile_setting{
+		{"comment-re", "!"},
+		{"ignore-re", `^[ \t]*(exit|exit-address-family|exit-vrf|exit-vnc)?[ \t]*$`},
+	}},
+	{"ios", []profile_setting{
+		{"comment-re", "!"},
+		{"ignore-re", `^[ \t]*(exit-address-family|exit-peer-policy|exit-peer-session|exit-service-family)?[ \t]*$`},
+	}},
+	{"junos", []profile_setting{
+		{"comment-re", `^[ \t]*(#|/\*)`},
+		{"ignore-blank", "true"},
+	}},
+	{"make", []profile_setting{
+		{"comment-re", "#"},
+		{"ignore-blank", "true"},
+		{"indent-re", `^\t*`},
+	}},
+	{"nxos", []profile_setting{
+		{"comment-re", "!"},
+		{"ignore-blank", "true"},
+	}},
+	{"python", []profile_setting{
+		{"comment-re", "#"},
+		{"ignore-blank", "true"},
+	}},
+	{"yaml", []profile_setting{
+		{"yaml", "true"},
+	}},
+}
+
+// options setting the same section parameter as other options, a setting
+// is not applied if a related option is already set
+var related_options = map[string][]string{
+	"ignore-blank":    {"ignore-re"},
+	"ignore-re":       {"ignore-blank"},
+	"indent-re":       {"yaml", "yaml-seq-indent"},
+	"yaml":            {"indent-re", "yaml-seq-indent"},
+	"yaml-seq-indent": {"indent-re", "yaml"},
+	"binary-files":    {"text"},
+	"text":            {"binary-files"},
+}
+
+// alternative option names with their canonical option name
+var option_aliases = map[string]string{
+	"F":      "fixed-string",
+	"V":      "version",
+	"a":      "text",
+	"f":      "follow",
+	"h":      "help",
+	"i":      "ignore-case",
+	"j":      "jobs",
+	"n":      "line-number",
+	"q":      "quiet",
+	"silent": "quiet",
+}
+
+// determine the canonical name of an option
+func canonical_option(name string) string {
+	if c, ok := option_aliases[name]; ok {
+		return c
+	}
+	return name
+}
+
 // parameterize section algorithm
 type section_params struct {
 	// options
-	enclosing       bool
-	fixed_string    bool
-	headers         bool
-	ignore_blank    bool
-	ignore_case     bool
-	invert_match    bool
-	omit_ignored    bool
-	stdin_label     string
-	tab_is_n_spaces bool
-	tab_size        int
-	top_level       bool
-	yaml_ind        bool
+	binary_files       int
+	binary_utf8        bool
+	enclosing          bool
+	encoding           *text_encoding
+	fixed_string       bool
+	flatten            bool
+	flatten_sep        string
+	following_siblings int
+	headers            bool
+	ignore_blank       bool
+	ignore_case        bool
+	indent_width       int
+	input_format       int
+	invert_match       bool
+	long_lines         int
+	max_buffer         int
+	max_line_length    int
+	no_match_comments  bool
+	omit_ignored       bool
+	output_format      int
+	parent             int
+	preserve_eol       bool
+	reencode           bool
+	siblings           bool
+	sort_depth         int
+	sort_order         int
+	stdin_label        string
+	tab_is_n_spaces    bool
+	tab_size           int
+	top_level          bool
+	unflatten          bool
+	uniq_sections      bool
+	yaml               bool
+	yaml_ind           bool
 	// regular expression matching prefix in front of indentation
 	ignore_prefix_re *regexp.Regexp
 	// regular expression matching indentation
 	ind_re *regexp.Regexp
 	// regular expression matching lines to ignore
 	ignore_re *regexp.Regexp
+	// regular expression matching comment lines
+	comment_re *regexp.Regexp
 	// regular expression matching sections
 	pat_re *regexp.Regexp
+	// template expanded with submatches of pat_re to label sections
+	label_tmpl []byte
+	// regular expression selecting the sort key of a section header
+	sort_key_re *regexp.Regexp
+	// filter for sections based on their contents
+	filter *body_filter
+	// key path selecting nodes of a structured document instead of PATTERN
+	key_path key_path
+	// matching lines, e.g., by IP address, instead of PATTERN
+	line_match line_matcher
 	// memory for processed lines
 	memory line_memory
+	// output used by the line memory, e.g., to report binary matches
+	output line_output
 }
 
 // line printer object
@@ -121,6 +334,7 @@ type line_printer struct {
 	is_printing      bool
 	quiet            bool
 	select_rest      bool
+	label            []byte // label of the line being printed
 	// values
 	file_header_prefix    string
 	file_header_suffix    string
@@ -132,516 +346,5066 @@ type line_printer struct {
 	begin          bool
 	file_header    bool
 	file_separator bool
+	label_section  bool
 	line_number    bool
 	omit           bool
 	separator      bool
 	with_filename  bool
+	// line terminator, empty if lines include their terminator
+	line_end string
+	// output destination
+	out io.Writer
+	// formatting of printed lines
+	formatter line_formatter
 }
 
 // method to possibly print a line, depending on state and parameters
-func (p *line_printer) print_line(l *[]byte, nr uint64, tr bool, is bool) (err error) {
+func (p *line_printer) print_line(l *line, tr bool, is bool) (err error) {
 	omit_selected := p.omit && (is || p.select_rest)
 	omit_unselected := !p.omit && !(is || p.select_rest)
 	is_transition := (tr || (!p.is_printing && p.omit)) && !p.select_rest
 	if p.begin && is {
 		p.select_rest = true
 	}
-	if p.quiet || omit_selected || omit_unselected {
+	if p.quiet {
+		p.is_printing = false
+		return nil
+	}
+	p.formatter.track(l)
+	if omit_selected || omit_unselected {
 		p.is_printing = false
 		return nil
 	}
+	// added lines use the line terminator of the printed line
+	nl := p.line_end
+	if nl == "" {
+		nl = line_ending(l.data)
+	}
 	if p.file_separator && !p.has_printed_file && p.has_printed {
-		_, err = os.Stdout.WriteString(p.file_separator_string + "\n")
+		_, err = io.WriteString(p.out, p.file_separator_string+nl)
 		if err != nil {
 			return
 		}
 	}
 	if p.file_header && !p.has_printed_file {
-		_, err = os.Stdout.WriteString(p.file_header_prefix +
-			p.filename + p.file_header_suffix + "\n")
+		_, err = io.WriteString(p.out,
+			p.file_header_prefix+p.filename+p.file_header_suffix+nl)
 		if err != nil {
 			return
 		}
 	}
 	if p.separator && p.has_printed && is_transition {
-		_, err = os.Stdout.WriteString(p.separator_string + "\n")
+		_, err = io.WriteString(p.out, p.separator_string+nl)
+		if err != nil {
+			return
+		}
+	}
+	p.label = l.label
+	err = p.formatter.format_line(p, l.data, l.nr, is, is_transition)
+	if err != nil {
+		return
+	}
+	p.has_printed = true
+	p.has_printed_file = true
+	p.is_printing = true
+	return
+}
+
+// method to complete the output for the current input
+func (p *line_printer) finish() error {
+	if p.quiet {
+		return nil
+	}
+	return p.formatter.finish(p)
+}
+
+// method to complete the output at the end of all input
+func (p *line_printer) close() error {
+	if p.quiet {
+		return nil
+	}
+	return p.formatter.close(p)
+}
+
+// method to report a match in binary input instead of printing lines
+func (p *line_printer) print_binary_match() (err error) {
+	if p.quiet {
+		return nil
+	}
+	if p.file_separator && !p.has_printed_file && p.has_printed {
+		_, err = io.WriteString(p.out, p.file_separator_string+"\n")
 		if err != nil {
 			return
 		}
 	}
+	_, err = fmt.Fprintf(p.out, "Binary file %s matches\n", p.filename)
+	if err != nil {
+		return
+	}
+	p.has_printed = true
+	p.has_printed_file = true
+	return
+}
+
+// method to print an error message
+func (p *line_printer) print_error(err error) {
+	print_err(err)
+}
+
+// method to select the character encoding of the output, nil for
+// printing lines unchanged
+func (p *line_printer) set_encoding(enc *text_encoding, bom bool) {
+	if enc == nil {
+		p.out = os.Stdout
+		return
+	}
+	p.out = &encoding_writer{
+		w:   os.Stdout,
+		enc: enc,
+		bom: bom && !p.has_printed,
+	}
+}
+
+// interface to format printed lines
+type line_formatter interface {
+	// consider a line given to the line printer, printed or not
+	track(l *line)
+	// print a line, optionally starting a new section
+	format_line(p *line_printer, l []byte, nr uint64, is bool, tr bool) error
+	// complete the output for the current input
+	finish(p *line_printer) error
+	// complete the output at the end of all input
+	close(p *line_printer) error
+}
+
+// line formatter printing lines with optional file name, line number, and
+// label prefixes
+type prefix_formatter struct{}
+
+// prefix formatting does not depend on other lines
+func (f prefix_formatter) track(l *line) {
+}
+
+// print a line with optional prefixes
+func (f prefix_formatter) format_line(p *line_printer, l []byte, nr uint64, is bool, tr bool) (err error) {
 	if p.with_filename {
-		_, err = os.Stdout.WriteString(p.filename + p.prefix_delim)
+		_, err = io.WriteString(p.out, p.filename+p.prefix_delim)
 		if err != nil {
 			return
 		}
 	}
 	if p.line_number {
-		_, err = fmt.Printf("%d%s", nr, p.prefix_delim)
+		_, err = fmt.Fprintf(p.out, "%d%s", nr, p.prefix_delim)
+		if err != nil {
+			return
+		}
+	}
+	if p.label_section {
+		_, err = fmt.Fprintf(p.out, "%s%s", p.label, p.prefix_delim)
 		if err != nil {
 			return
 		}
 	}
-	_, err = os.Stdout.Write(*l)
+	_, err = p.out.Write(l)
 	if err != nil {
 		return
 	}
-	p.has_printed = true
-	p.has_printed_file = true
-	p.is_printing = true
-	_, err = os.Stdout.WriteString("\n")
+	_, err = io.WriteString(p.out, p.line_end)
 	return
 }
 
-// one line with added information
-type line struct {
-	l_ind    int    // indentation level of this line
-	s_ind    int    // indentation level of section this line is in
-	selected bool   // is this line selected as part of a section?
-	nr       uint64 // line number
-	data     []byte // the bytes constituting the line itself
+// nothing to complete for prefix formatting
+func (f prefix_formatter) finish(p *line_printer) error {
+	return nil
 }
 
-// interface to a collection of lines with added information
-type line_memory interface {
-	set_act(lp *line_printer)
-	set_ign(lp *line_printer)
-	add_headers()
-	get_with_headers() bool
-	add(l *[]byte, nr uint64, l_ind, s_ind int) (int, error)
-	flush() (err error)
+// nothing to complete at the end of the output
+func (f prefix_formatter) close(p *line_printer) error {
+	return nil
 }
 
-// a collection of lines with added information for a generic implementation
-// of the simple ("memoryless") section algorithm
-// (this is not a memoryless implementation)
-type simple_line_memory struct {
-	lines        *[]line
-	act          *line_printer // default output function
-	ign          *line_printer // output function for ignored lines
-	with_headers bool          // add headers of selected sections
+// information about a printed line available to --format and
+// --section-format templates
+type format_data struct {
+	Filename   string   // file name, or label for standard input
+	LineNumber uint64   // line number
+	Label      string   // label from --label-section
+	Depth      int      // indentation depth, -1 for ignored lines
+	Section    int      // number of the section in the output
+	Selected   bool     // is the line selected?
+	Matched    bool     // does the line match the PATTERN?
+	Headers    []string // header lines of the enclosing sections
+	Line       string   // the line without its line terminator
+	End        bool     // does the section end (--section-format only)?
 }
 
-// set the line printer for normal lines
-func (lm *simple_line_memory) set_act(lp *line_printer) {
-	lm.act = lp
+// line formatter using templates for lines and section headers and
+// footers
+type template_formatter struct {
+	header_tracker
+	line_tmpl    *template.Template // template for lines, nil for prefixes
+	section_tmpl *template.Template // template for section headers/footers
+	section      int                // number of the current section
+	open         bool               // does a section need a footer?
+	last         format_data        // data of the last printed line
 }
 
-// set the line printer for ignored lines
-func (lm *simple_line_memory) set_ign(lp *line_printer) {
-	lm.ign = lp
+// tracking of the indentation depth and the header lines of the enclosing
+// sections for lines given to a line printer
+type header_tracker struct {
+	headers     []string // header lines of the current line
+	depths      []int    // indentation depths of the headers
+	depth       int      // indentation depth of the current line
+	matched     bool     // does the current line match the pattern?
+	cur_headers []string // header lines of the enclosing sections
 }
 
-// set the with_headers flag to also select section headers
-func (lm *simple_line_memory) add_headers() {
-	lm.with_headers = true
+// template functions available to --format and --section-format
+var format_funcs = template.FuncMap{
+	"join": strings.Join,
 }
 
-// query value of with_headers flag
-func (lm *simple_line_memory) get_with_headers() bool {
-	return lm.with_headers
+// track the header lines of the enclosing sections for every line, using
+// the indentation depth determined when reading the input line
+func (t *header_tracker) track(l *line) {
+	text := l.text
+	if text == nil {
+		text = l.data
+	}
+	text = trim_eol(text)
+	t.matched = l.matched
+	t.depth = l.l_ind
+	if t.depth == -1 {
+		t.cur_headers = t.headers
+		return
+	}
+	n := len(t.depths)
+	for n > 0 && t.depths[n-1] >= t.depth {
+		n--
+	}
+	t.headers = t.headers[:n:n]
+	t.depths = t.depths[:n]
+	t.cur_headers = t.headers
+	t.headers = append(t.headers, string(bytes.TrimSpace(text)))
+	t.depths = append(t.depths, t.depth)
 }
 
-// add a line to the collection according to simple ("memoryless") rules for
-// a generic implementation that does use extra memory to memorize lines
-func (lm *simple_line_memory) add(l *[]byte, nr uint64, l_ind, s_ind int) (int, error) {
-	// create a new data structure for the line
-	new_line := line{
-		l_ind:    l_ind,
-		s_ind:    s_ind,
-		selected: s_ind > -1,
-		nr:       nr,
-	}
-	new_line.data = make([]byte, len(*l))
-	copy(new_line.data, *l)
-	// ensure existence of lines slice to allow appending a line
-	if lm.lines == nil {
-		lm.lines = new([]line)
+// forget the header lines of the current input
+func (t *header_tracker) reset() {
+	t.headers = nil
+	t.depths = nil
+}
+
+// print a section header or footer line, unless it is empty
+func (f *template_formatter) print_section(p *line_printer, data format_data, nl string) error {
+	var buf bytes.Buffer
+	err := f.section_tmpl.Execute(&buf, data)
+	if err != nil || buf.Len() == 0 {
+		return err
 	}
-	// append the line
-	*lm.lines = append(*lm.lines, new_line)
-	// the simple ("memoryless") section algorithm does not adjust meta
-	// data of previous lines, and does not adjust the section indentation
-	// level
-	return s_ind, nil
+	buf.WriteString(nl)
+	_, err = p.out.Write(buf.Bytes())
+	return err
 }
 
-// optionally add headers of selected sections, then print the contents of
-// a line collection and clear it
-// this works identically for generic implementations of the "memoryless",
-// "top level", and "enclosing" section algorithm variants
-func (lm *simple_line_memory) flush() (err error) {
-	prev_sect := false
-	in_sect := false
-	cont_sect := false
-	new_sect := false
-	// nothing to do if there are no saved lines
-	if lm.lines == nil {
+// print a section footer for the previous section, if any
+func (f *template_formatter) end_section(p *line_printer, nl string) error {
+	if !f.open {
 		return nil
 	}
-	// optionally add header lines of selected sections
-	// this also changes the indentation depth to the top level
-	var l line
-	if lm.get_with_headers() {
-		// determine minimum, i.e., top level, indentation
-		min_ind := -1
-		for _, l = range *lm.lines {
-			if l.l_ind != -1 {
-				min_ind = l.l_ind
-				break
-			}
-		}
-		// add headers and set section indentation to top level
-		last_ind := -1
-		nr_lines := len(*lm.lines)
-		i := nr_lines - 1
-		for ; i >= 0; i-- {
-			// ignored lines do not count as section headers
-			if (*lm.lines)[i].l_ind == -1 {
-				continue
-			}
-			// find last line selected as inside a section
-			if last_ind == -1 {
-				if (*lm.lines)[i].selected {
-					last_ind = (*lm.lines)[i].s_ind
-					(*lm.lines)[i].s_ind = min_ind
-				} else {
-					continue
-				}
-			}
-			// then add section headers to selected lines
-			if (*lm.lines)[i].l_ind < last_ind {
-				(*lm.lines)[i].selected = true
-				last_ind = (*lm.lines)[i].l_ind
-			} else if (*lm.lines)[i].l_ind > last_ind && (*lm.lines)[i].selected {
-				last_ind = (*lm.lines)[i].l_ind
-			}
-			// all lines are inside the top level section
-			(*lm.lines)[i].s_ind = min_ind
-		}
+	f.open = false
+	f.last.End = true
+	return f.print_section(p, f.last, nl)
+}
+
+// print a line according to the templates
+func (f *template_formatter) format_line(p *line_printer, l []byte, nr uint64, is bool, tr bool) (err error) {
+	text := trim_eol(l)
+	nl := p.line_end
+	if nl == "" {
+		nl = line_ending(l)
 	}
-	// send lines to line printer
-	for _, l = range *lm.lines {
-		in_sect = l.s_ind > -1
-		// ignore lines with unspecified indentation level
-		if l.l_ind == -1 {
-			err = lm.ign.print_line(&l.data, l.nr, false, l.selected)
-			if err != nil {
-				break
-			}
-			continue
+	if tr {
+		f.section++
+	}
+	data := format_data{
+		Filename:   p.filename,
+		LineNumber: nr,
+		Label:      string(p.label),
+		Depth:      f.depth,
+		Section:    f.section,
+		Selected:   is,
+		Matched:    f.matched,
+		Headers:    f.cur_headers,
+		Line:       string(text),
+	}
+	if tr && f.section_tmpl != nil {
+		err = f.end_section(p, nl)
+		if err != nil {
+			return
 		}
-		cont_sect = in_sect && l.l_ind > l.s_ind
-		new_sect = in_sect && (!cont_sect || !prev_sect)
-		prev_sect = in_sect
-		err = lm.act.print_line(&l.data, l.nr, new_sect, l.selected)
+		err = f.print_section(p, data, nl)
 		if err != nil {
-			break
+			return
 		}
+		f.open = true
 	}
-	lm.lines = nil
+	f.last = data
+	if f.line_tmpl == nil {
+		return prefix_formatter{}.format_line(p, l, nr, is, tr)
+	}
+	err = f.line_tmpl.Execute(p.out, data)
+	if err != nil {
+		return
+	}
+	_, err = p.out.Write(l[len(text):])
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(p.out, p.line_end)
 	return
 }
 
-// memoryless implementation of simple ("memoryless") section algorithm
-// this implementation differs from the generic one by not memorizing lines
-type memoryless_lm struct {
-	act *line_printer // default output function
-	ign *line_printer // output function for ignored lines
+// print the footer of the last section, and forget the headers of the
+// current input
+func (f *template_formatter) finish(p *line_printer) error {
+	f.reset()
+	nl := p.line_end
+	if nl == "" {
+		nl = "\n"
+	}
+	return f.end_section(p, nl)
 }
 
-// set the line printer for normal lines for memoryless implementation
-func (lm *memoryless_lm) set_act(lp *line_printer) {
-	lm.act = lp
+// nothing to complete at the end of the output
+func (f *template_formatter) close(p *line_printer) error {
+	return nil
 }
 
-// set the line printer for ignored lines for memoryless implementation
-func (lm *memoryless_lm) set_ign(lp *line_printer) {
-	lm.ign = lp
+// one printed line as part of the tree of printed lines of an input
+type html_line struct {
+	text     string
+	nr       uint64
+	depth    int
+	matched  bool
+	children []*html_line
 }
 
-// memoryless implementation does not support adding headers
-func (lm *memoryless_lm) add_headers() {
-}
+// beginning of the HTML document printed with --output=html
+const HTML_PROLOGUE = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>section</title>
+<style>
+body { font-family: sans-serif; }
+summary { cursor: pointer; }
+.r { margin-left: 1.2em; }
+.n { display: inline-block; min-width: 3em; margin-right: 1em; color: #888; text-align: right; text-decoration: none; font-family: monospace; }
+.l { font-family: monospace; white-space: pre; }
+.m { background-color: #ff8; }
+</style>
+</head>
+<body>
+`
 
-// memoryless implementation does not support adding headers
-func (lm *memoryless_lm) get_with_headers() bool {
-	return false
+// end of the HTML document printed with --output=html
+const HTML_EPILOGUE = `</body>
+</html>
+`
+
+// line formatter creating an HTML document with collapsible sections
+type html_formatter struct {
+	header_tracker
+	started bool         // has the document been started?
+	file    int          // number of the current input
+	lines   []*html_line // top level lines of the current input
+	stack   []*html_line // lines enclosing the current line
+	depth_i int          // indentation depth used for ignored lines
+	limit   buffer_limit // limit memory used for memorized lines
 }
 
-// the simple section algorithm can be implemented "memoryless", i.e.,
-// without saving any lines, by just printing them
-func (lm *memoryless_lm) add(l *[]byte, nr uint64, l_ind, s_ind int) (int, error) {
-	var err error
-	in_sect := s_ind > -1
-	cont_sect := in_sect && l_ind > s_ind
-	new_sect := in_sect && !cont_sect
-	if l_ind == -1 {
-		err = lm.ign.print_line(l, nr, false, in_sect)
+// memorize a line as part of the tree of printed lines
+func (f *html_formatter) format_line(p *line_printer, l []byte, nr uint64, is bool, tr bool) error {
+	text := trim_eol(l)
+	err := f.limit.grow(len(text))
+	if err != nil {
+		// forget the lines of the current input
+		f.reset()
+		f.lines, f.stack = nil, nil
+		f.limit.size = 0
+		return err
+	}
+	hl := &html_line{
+		text:    string(text),
+		nr:      nr,
+		depth:   f.depth,
+		matched: f.matched,
+	}
+	// ignored lines are siblings of the previous line
+	if hl.depth == -1 {
+		hl.depth = f.depth_i
+	}
+	f.depth_i = hl.depth
+	n := len(f.stack)
+	for n > 0 && f.stack[n-1].depth >= hl.depth {
+		n--
+	}
+	f.stack = f.stack[:n]
+	if n == 0 {
+		f.lines = append(f.lines, hl)
 	} else {
-		err = lm.act.print_line(l, nr, new_sect, in_sect)
+		f.stack[n-1].children = append(f.stack[n-1].children, hl)
 	}
-	return s_ind, err
-}
-
-// nothing to do for "memoryless" implementation, but required to implement
-// the line_memory interface
-func (lm *memoryless_lm) flush() error {
+	f.stack = append(f.stack, hl)
 	return nil
 }
 
-// a collection of lines with added information for the "top level"
-// section algorithm
-type top_level_lm struct {
-	simple_line_memory
-	matched bool
+// write one line with its children as HTML
+func (f *html_formatter) write_line(buf *bytes.Buffer, hl *html_line) {
+	id := fmt.Sprintf("f%d-L%d", f.file, hl.nr)
+	class := "l"
+	if hl.matched {
+		class = "l m"
+	}
+	row := fmt.Sprintf(`<a class="n" id="%s" href="#%s">%d</a><span class="%s">%s</span>`,
+		id, id, hl.nr, class, html.EscapeString(hl.text))
+	if len(hl.children) == 0 {
+		buf.WriteString(`<div class="r">` + row + "</div>\n")
+		return
+	}
+	buf.WriteString("<details open><summary>" + row + "</summary>\n")
+	var c *html_line
+	for _, c = range hl.children {
+		f.write_line(buf, c)
+	}
+	buf.WriteString("</details>\n")
 }
 
-// set the line printer for normal lines for "top level" implementation
-func (lm *top_level_lm) set_act(lp *line_printer) {
-	lm.simple_line_memory.act = lp
+// print the lines of the current input as one HTML section
+func (f *html_formatter) finish(p *line_printer) (err error) {
+	f.reset()
+	lines := f.lines
+	f.lines, f.stack = nil, nil
+	f.limit.size = 0
+	if len(lines) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if !f.started {
+		f.started = true
+		buf.WriteString(HTML_PROLOGUE)
+	}
+	f.file++
+	buf.WriteString("<section>\n<h2>" + html.EscapeString(p.filename) + "</h2>\n")
+	var hl *html_line
+	for _, hl = range lines {
+		f.write_line(&buf, hl)
+	}
+	buf.WriteString("</section>\n")
+	_, err = p.out.Write(buf.Bytes())
+	return
 }
 
-// set the line printer for ignored lines for "top level" implementation
-func (lm *top_level_lm) set_ign(lp *line_printer) {
-	lm.simple_line_memory.ign = lp
+// complete the HTML document
+func (f *html_formatter) close(p *line_printer) (err error) {
+	if f.started {
+		_, err = io.WriteString(p.out, HTML_EPILOGUE)
+	}
+	return
 }
 
-// all headers are already part of the selected "top level" section
-func (lm *top_level_lm) add_headers() {
+// line formatter creating Markdown with a fenced code block per input
+type markdown_formatter struct {
+	started bool         // has anything been printed?
+	buf     bytes.Buffer // printed lines of the current input
+	limit   buffer_limit // limit memory used for memorized lines
 }
 
-// no special action required for headers, so always return false
-func (lm *top_level_lm) get_with_headers() bool {
-	return false
+// Markdown formatting does not depend on other lines
+func (f *markdown_formatter) track(l *line) {
 }
 
-// add a line to the collection according to "top level" section rules
-func (lm *top_level_lm) add(l *[]byte, nr uint64, l_ind, s_ind int) (int, error) {
-	var err error
-	// as soon as the pattern has been matched, all lines can be sent
-	// to the line printer instead of saving a copy for later
-	if lm.matched {
-		if l_ind == -1 {
-			err = lm.ign.print_line(l, nr, false, true)
-		} else {
-			err = lm.act.print_line(l, nr, false, true)
-		}
-		return s_ind, err
+// memorize a line with optional prefixes
+func (f *markdown_formatter) format_line(p *line_printer, l []byte, nr uint64, is bool, tr bool) error {
+	out, line_end := p.out, p.line_end
+	p.out = &f.buf
+	if line_end == "" && !bytes.HasSuffix(l, []byte("\n")) {
+		p.line_end = "\n"
+	}
+	n := f.buf.Len()
+	err := prefix_formatter{}.format_line(p, l, nr, is, tr)
+	p.out, p.line_end = out, line_end
+	if err == nil {
+		err = f.limit.grow(f.buf.Len() - n)
 	}
-	// no pattern match yet, so save the line
-	_, err = lm.simple_line_memory.add(l, nr, l_ind, s_ind)
 	if err != nil {
-		return s_ind, err
+		// forget the lines of the current input
+		f.buf.Reset()
+		f.limit.size = 0
 	}
-	// ignored lines do not affect section meta data
-	if l_ind == -1 {
-		return s_ind, err
+	return err
+}
+
+// characters starting or ending Markdown inline elements, e.g., emphasis,
+// links, or HTML, and the closing sequence of a heading
+const MARKDOWN_SPECIAL = "\\`*_[]<>&!~|#"
+
+// escape characters with a special meaning in Markdown inline text, and
+// replace line breaks, which would end a heading
+func markdown_escape(s string) string {
+	var b strings.Builder
+	var r rune
+	for _, r = range s {
+		if r == '\n' || r == '\r' {
+			r = ' '
+		} else if strings.ContainsRune(MARKDOWN_SPECIAL, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
 	}
-	// only pattern match can affect section meta data
-	if l_ind != s_ind {
+	return b.String()
+}
+
+// print the lines of the current input as a fenced code block below a
+// heading showing the file name
+func (f *markdown_formatter) finish(p *line_printer) (err error) {
+	if f.buf.Len() == 0 {
+		return nil
+	}
+	// the fence needs to be longer than any sequence of backticks
+	fence := 3
+	run := 0
+	var b byte
+	for _, b = range f.buf.Bytes() {
+		if b == '`' {
+			run++
+			if run >= fence {
+				fence = run + 1
+			}
+		} else {
+			run = 0
+		}
+	}
+	var out bytes.Buffer
+	if f.started {
+		out.WriteString("\n")
+	}
+	f.started = true
+	out.WriteString("## " + markdown_escape(p.filename) + "\n\n")
+	out.WriteString(strings.Repeat("`", fence) + "\n")
+	out.Write(f.buf.Bytes())
+	out.WriteString(strings.Repeat("`", fence) + "\n")
+	f.buf.Reset()
+	f.limit.size = 0
+	_, err = p.out.Write(out.Bytes())
+	return
+}
+
+// nothing to complete at the end of the output
+func (f *markdown_formatter) close(p *line_printer) error {
+	return nil
+}
+
+// column of a table created with --table
+type table_column struct {
+	name string         // column name for the header row
+	re   *regexp.Regexp // regular expression extracting the value
+}
+
+// table formats for --table and their field delimiters
+var table_formats = map[string]rune{
+	"csv": ',',
+	"tsv": '\t',
+}
+
+// line formatter printing one table row per section
+type table_formatter struct {
+	columns []table_column
+	comma   rune        // field delimiter
+	started bool        // has the header row been printed?
+	row     []string    // values of the current row, nil if no row started
+	file    string      // file name of the current row
+	nr      uint64      // line number of the first line of the current row
+	label   string      // label of the first line of the current row
+	w       *csv.Writer // writer of the table records
+	out     io.Writer   // destination of the CSV writer
+}
+
+// table rows do not depend on lines that are not printed
+func (f *table_formatter) track(l *line) {
+}
+
+// print one record of the table, the CSV writer is created for the output
+// of the line printer, which may change with the character encoding
+func (f *table_formatter) write(p *line_printer, record []string) error {
+	if f.w == nil || f.out != p.out {
+		f.w = csv.NewWriter(p.out)
+		f.w.Comma = f.comma
+		f.w.UseCRLF = p.line_end == "\r\n"
+		f.out = p.out
+	}
+	f.w.Write(record)
+	f.w.Flush()
+	return f.w.Error()
+}
+
+// print the header row of the table
+func (f *table_formatter) write_header(p *line_printer) error {
+	f.started = true
+	var record []string
+	if p.with_filename {
+		record = append(record, "file")
+	}
+	if p.line_number {
+		record = append(record, "line")
+	}
+	if p.label_section {
+		record = append(record, "label")
+	}
+	var c table_column
+	for _, c = range f.columns {
+		record = append(record, c.name)
+	}
+	return f.write(p, record)
+}
+
+// print the current row, if any
+func (f *table_formatter) end_row(p *line_printer) (err error) {
+	if f.row == nil {
+		return nil
+	}
+	if !f.started {
+		err = f.write_header(p)
+		if err != nil {
+			return
+		}
+	}
+	var record []string
+	if p.with_filename {
+		record = append(record, f.file)
+	}
+	if p.line_number {
+		record = append(record, strconv.FormatUint(f.nr, 10))
+	}
+	if p.label_section {
+		record = append(record, f.label)
+	}
+	record = append(record, f.row...)
+	f.row = nil
+	return f.write(p, record)
+}
+
+// extract column values from a line of the current section, a new section
+// starts a new row
+func (f *table_formatter) format_line(p *line_printer, l []byte, nr uint64, is bool, tr bool) (err error) {
+	if tr || f.row == nil {
+		err = f.end_row(p)
+		if err != nil {
+			return
+		}
+		f.row = make([]string, len(f.columns))
+		f.file = p.filename
+		f.nr = nr
+		f.label = string(p.label)
+	}
+	text := trim_eol(l)
+	var i int
+	var c table_column
+	for i, c = range f.columns {
+		m := c.re.FindSubmatch(text)
+		if m == nil {
+			continue
+		}
+		v := m[0]
+		if len(m) > 1 {
+			v = m[1]
+		}
+		if f.row[i] != "" {
+			f.row[i] += " "
+		}
+		f.row[i] += string(v)
+	}
+	return nil
+}
+
+// print the last row of the current input
+func (f *table_formatter) finish(p *line_printer) error {
+	return f.end_row(p)
+}
+
+// print at least the header row
+func (f *table_formatter) close(p *line_printer) error {
+	if f.started {
+		return nil
+	}
+	return f.write_header(p)
+}
+
+// the line terminator to use for lines added in front of a line, i.e.,
+// "\r\n" for a line ending in "\r\n", and "\n" otherwise
+func line_ending(l []byte) string {
+	if bytes.HasSuffix(l, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// a line without its line terminator, if any
+func trim_eol(l []byte) []byte {
+	if bytes.HasSuffix(l, []byte("\n")) {
+		l = l[:len(l)-1]
+	}
+	if bytes.HasSuffix(l, []byte("\r")) {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+// a list of strings given via repeated command line options
+type string_list []string
+
+// format a string list for the flag package
+func (sl *string_list) String() string {
+	if sl == nil {
+		return ""
+	}
+	return strings.Join(*sl, ", ")
+}
+
+// add a string to a string list for the flag package
+func (sl *string_list) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+// a string option that can also be given without a value, like a boolean
+// option
+type optional_string struct {
+	value string
+	def   string // value used if the option is given without a value
+}
+
+// format an optional string for the flag package
+func (o *optional_string) String() string {
+	if o == nil {
+		return ""
+	}
+	return o.value
+}
+
+// set an optional string for the flag package, "true" selects the
+// default value, "false" the empty string
+func (o *optional_string) Set(s string) error {
+	if s == "true" {
+		s = o.def
+	} else if s == "false" {
+		s = ""
+	}
+	o.value = s
+	return nil
+}
+
+// the option can be given without a value
+func (o *optional_string) IsBoolFlag() bool {
+	return true
+}
+
+// something matching lines, e.g., a regular expression
+type line_matcher interface {
+	Match(l []byte) bool
+}
+
+// filter to select sections based on the lines comprising a section
+type body_filter struct {
+	contains     []line_matcher // each must match a line of the section
+	not_contains []line_matcher // none may match a line of the section
+	passed       bool           // has any section passed the filter?
+}
+
+// check if the lines of one section satisfy the filter
+// (lines ignored for section boundary determination are not considered)
+func (f *body_filter) check(lines []line) bool {
+	var re line_matcher
+	var i int
+	var found bool
+	for _, re = range f.contains {
+		found = false
+		for i = range lines {
+			if lines[i].l_ind != -1 && re.Match(trim_eol(lines[i].data)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, re = range f.not_contains {
+		for i = range lines {
+			if lines[i].l_ind != -1 && re.Match(trim_eol(lines[i].data)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// deselect all sections not satisfying the filter
+// a section comprises consecutive selected lines, and a new section starts
+// with a selected line at the section indentation level
+func (f *body_filter) apply(lines []line) {
+	start := -1
+	var i, j int
+	for i = 0; i <= len(lines); i++ {
+		ends := i == len(lines) || !lines[i].selected ||
+			(lines[i].l_ind != -1 && lines[i].l_ind <= lines[i].s_ind) ||
+			lines[i].selected_alone()
+		if ends && start > -1 {
+			if f.check(lines[start:i]) {
+				f.passed = true
+			} else {
+				for j = start; j < i; j++ {
+					lines[j].selected = false
+					lines[j].s_ind = -1
+				}
+			}
+			start = -1
+		}
+		if i < len(lines) && lines[i].selected && start == -1 {
+			start = i
+		}
+	}
+}
+
+// interface to print lines, or to record them for printing later
+type line_output interface {
+	print_line(l *line, tr bool, is bool) error
+	print_binary_match() error
+	finish() error
+	set_encoding(enc *text_encoding, bom bool)
+	print_error(err error)
+}
+
+// one recorded call of the print_line method
+type print_event struct {
+	l    line  // the line to print
+	tr   bool  // is this a section transition?
+	is   bool  // is this line selected?
+	skip bool  // represents one or more lines that would not be printed
+	bin  bool  // represents a match in binary input
+	fin  bool  // represents the end of the input
+	err  error // represents an error message
+}
+
+// line output recording lines for printing them later, e.g., to print
+// the results of concurrently processed files in argument order
+// lines that would not be printed are recorded as a single skip event
+type print_recorder struct {
+	// state
+	select_rest bool
+	events      []print_event
+	enc         *text_encoding // output character encoding
+	bom         bool           // print a byte order mark?
+	// features (copied from the line printer used for replaying)
+	begin bool
+	omit  bool
+	quiet bool
+	all   bool // record lines that would not be printed, too
+}
+
+// record a line instead of printing it
+func (r *print_recorder) print_line(l *line, tr bool, is bool) error {
+	printed := !r.quiet && r.omit != (is || r.select_rest)
+	if r.begin && is {
+		r.select_rest = true
+	}
+	nr_ev := len(r.events)
+	if !printed && !r.all {
+		if nr_ev > 0 && r.events[nr_ev-1].skip {
+			r.events[nr_ev-1].is = r.events[nr_ev-1].is || is
+		} else {
+			r.events = append(r.events, print_event{is: is, skip: true})
+		}
+		return nil
+	}
+	ev := print_event{l: *l, tr: tr, is: is}
+	ev.l.data = append([]byte(nil), l.data...)
+	if l.text != nil {
+		ev.l.text = append([]byte(nil), l.text...)
+	}
+	r.events = append(r.events, ev)
+	return nil
+}
+
+// record the end of the input
+func (r *print_recorder) finish() error {
+	r.events = append(r.events, print_event{fin: true})
+	return nil
+}
+
+// record a match in binary input
+func (r *print_recorder) print_binary_match() error {
+	r.events = append(r.events, print_event{bin: true})
+	return nil
+}
+
+// record an error message, which is printed when replaying to keep it in
+// order with the lines of its file
+func (r *print_recorder) print_error(err error) {
+	r.events = append(r.events, print_event{err: err})
+}
+
+// remember the output character encoding to use when replaying
+func (r *print_recorder) set_encoding(enc *text_encoding, bom bool) {
+	r.enc = enc
+	r.bom = bom
+}
+
+// send recorded lines to a line printer
+func (r *print_recorder) replay(lp *line_printer) (err error) {
+	var i int
+	var ev *print_event
+	lp.set_encoding(r.enc, r.bom)
+	for i = range r.events {
+		ev = &r.events[i]
+		if ev.err != nil {
+			lp.print_error(ev.err)
+		} else if ev.bin {
+			err = lp.print_binary_match()
+		} else if ev.fin {
+			err = lp.finish()
+		} else {
+			err = lp.print_line(&ev.l, ev.tr, ev.is)
+		}
+		if err != nil {
+			break
+		}
+	}
+	r.events = nil
+	return
+}
+
+// one line with added information
+type line struct {
+	l_ind    int    // indentation level of this line
+	s_ind    int    // indentation level of section this line is in
+	selected bool   // is this line selected as part of a section?
+	nr       uint64 // line number
+	data     []byte // the bytes constituting the line itself
+	label    []byte // label of the section from --label-section
+	comment  bool   // is this a comment line?
+	matched  bool   // does the line match the pattern?
+	text     []byte // the input line, if data has been changed for output
+}
+
+// check if a line is a comment line matching the pattern outside of a
+// section, which is selected on its own
+func (l *line) selected_alone() bool {
+	return l.comment && l.selected && l.s_ind == -1
+}
+
+// interface to a collection of lines with added information
+type line_memory interface {
+	set_act(lp line_output)
+	set_ign(lp line_output)
+	add_headers()
+	get_with_headers() bool
+	set_filter(f *body_filter)
+	set_limit(max int)
+	set_flatten(sep string)
+	set_label(label []byte)
+	add(l *[]byte, nr uint64, l_ind int, matched bool, s_ind int) (int, error)
+	add_comment(l *[]byte, nr uint64, matched bool, s_ind int) (int, error)
+	flush() (err error)
+}
+
+// estimated number of bytes used to memorize a line in addition to its
+// data, i.e., the bookkeeping structure of the line, which is about 100
+// bytes and memorized in a slice that may have twice the needed capacity,
+// and the allocation overhead of the line data
+const LINE_OVERHEAD = 256
+
+// limit for the amount of memory used for memorized lines
+type buffer_limit struct {
+	max  int // maximum number of bytes, 0 for no limit
+	size int // number of bytes currently memorized
+}
+
+// account for an additional line with n bytes of data, failing if the
+// limit is exceeded
+func (bl *buffer_limit) grow(n int) error {
+	bl.size += n + LINE_OVERHEAD
+	if bl.max > 0 && bl.size > bl.max {
+		return fmt.Errorf("memorized lines exceed --max-buffer limit of %d bytes", bl.max)
+	}
+	return nil
+}
+
+// account for a line with n bytes of data that is not memorized anymore
+func (bl *buffer_limit) shrink(n int) {
+	bl.size -= n + LINE_OVERHEAD
+}
+
+// a collection of lines with added information for a generic implementation
+// of the simple ("memoryless") section algorithm
+// (this is not a memoryless implementation)
+type simple_line_memory struct {
+	lines        *[]line
+	act          line_output  // default output function
+	ign          line_output  // output function for ignored lines
+	with_headers bool         // add headers of selected sections
+	filter       *body_filter // filter sections by their contents
+	buf          buffer_limit // limit memory used for memorized lines
+	label        []byte       // label of lines added next
+	flatten_sep  []byte       // prefix lines with their ancestors, if set
+}
+
+// set the line printer for normal lines
+func (lm *simple_line_memory) set_act(lp line_output) {
+	lm.act = lp
+}
+
+// set the line printer for ignored lines
+func (lm *simple_line_memory) set_ign(lp line_output) {
+	lm.ign = lp
+}
+
+// set the with_headers flag to also select section headers
+func (lm *simple_line_memory) add_headers() {
+	lm.with_headers = true
+}
+
+// query value of with_headers flag
+func (lm *simple_line_memory) get_with_headers() bool {
+	return lm.with_headers
+}
+
+// set the filter to select sections based on their contents
+func (lm *simple_line_memory) set_filter(f *body_filter) {
+	lm.filter = f
+}
+
+// set the maximum number of bytes of memorized lines
+func (lm *simple_line_memory) set_limit(max int) {
+	lm.buf.max = max
+}
+
+// prefix printed lines with their ancestor lines, using the given separator
+func (lm *simple_line_memory) set_flatten(sep string) {
+	lm.flatten_sep = []byte(sep)
+}
+
+// set the label of lines added next
+func (lm *simple_line_memory) set_label(label []byte) {
+	lm.label = label
+}
+
+// add a line to the collection according to simple ("memoryless") rules for
+// a generic implementation that does use extra memory to memorize lines
+func (lm *simple_line_memory) add(l *[]byte, nr uint64, l_ind int, matched bool, s_ind int) (int, error) {
+	err := lm.buf.grow(len(*l))
+	if err != nil {
+		return s_ind, err
+	}
+	// create a new data structure for the line
+	new_line := line{
+		l_ind:    l_ind,
+		s_ind:    s_ind,
+		selected: s_ind > -1,
+		nr:       nr,
+		label:    lm.label,
+		matched:  matched,
+	}
+	new_line.data = make([]byte, len(*l))
+	copy(new_line.data, *l)
+	// ensure existence of lines slice to allow appending a line
+	if lm.lines == nil {
+		lm.lines = new([]line)
+	}
+	// append the line
+	*lm.lines = append(*lm.lines, new_line)
+	// the simple ("memoryless") section algorithm does not adjust meta
+	// data of previous lines, and does not adjust the section indentation
+	// level
+	return s_ind, nil
+}
+
+// add a comment line to the collection, a comment line matching the pattern
+// is selected even outside of a section
+func (lm *simple_line_memory) add_comment(l *[]byte, nr uint64, matched bool, s_ind int) (int, error) {
+	_, err := lm.add(l, nr, -1, matched, s_ind)
+	if err != nil {
 		return s_ind, err
 	}
-	// all saved lines are part of the current section, and
-	// the current section has the indentation level of the first
-	// non-ignored line
-	// all saved lines can be sent to the appropriate line printer marked
-	// as "inside a section"
-	lm.matched = true
-	min_ind := -1
-	var sl *line
-	var new_sect bool
+	cl := &(*lm.lines)[len(*lm.lines)-1]
+	cl.comment = true
+	cl.selected = cl.selected || matched
+	return s_ind, nil
+}
+
+// select the output for a line without indentation level, comment lines
+// are printed like normal lines
+func (lm *simple_line_memory) ign_output(l *line) line_output {
+	if l.comment {
+		return lm.act
+	}
+	return lm.ign
+}
+
+// select the section started by the line preceding a comment line matching
+// the pattern outside of a section, climbing up further levels - 1
+// indentation levels, and return the section indentation level
+// (a comment line has no indentation level of its own, thus the preceding
+// line encloses it)
+func (lm *simple_line_memory) select_comment_parents(levels, s_ind int) int {
+	nr_lines := len(*lm.lines)
+	i := nr_lines - 2
+	for ; i >= 0 && (*lm.lines)[i].l_ind == -1; i-- {
+	}
+	if i < 0 {
+		return s_ind
+	}
+	start := i
+	s_ind = (*lm.lines)[i].l_ind
+	var lvl int
+	for lvl = 1; lvl < levels; lvl++ {
+		for i--; i >= 0; i-- {
+			if (*lm.lines)[i].l_ind != -1 && (*lm.lines)[i].l_ind < s_ind {
+				break
+			}
+		}
+		if i < 0 {
+			break
+		}
+		start = i
+		s_ind = (*lm.lines)[i].l_ind
+	}
+	for i = start; i < nr_lines; i++ {
+		(*lm.lines)[i].s_ind = s_ind
+		(*lm.lines)[i].selected = true
+		(*lm.lines)[i].label = lm.label
+	}
+	return s_ind
+}
+
+// determine the parent of each memorized line, i.e., the index of the last
+// preceding line with a lower indentation level, or -1 if there is none
+// (a line without indentation level, e.g., a comment line, is enclosed by
+// the preceding line with indentation level)
+func (lm *simple_line_memory) parents() []int {
+	par := make([]int, len(*lm.lines))
+	var stack []int // indexes of the ancestors of the current line
+	var i, n int
+	for i = range *lm.lines {
+		n = len(stack)
+		if (*lm.lines)[i].l_ind != -1 {
+			for n > 0 && (*lm.lines)[stack[n-1]].l_ind >= (*lm.lines)[i].l_ind {
+				n--
+			}
+			stack = stack[:n]
+		}
+		par[i] = -1
+		if n > 0 {
+			par[i] = stack[n-1]
+		}
+		if (*lm.lines)[i].l_ind != -1 {
+			stack = append(stack, i)
+		}
+	}
+	return par
+}
+
+// select the ancestors of selected lines as headers, and change the section
+// indentation level of all lines up to the last selected line to the top
+// level
+// ignored lines do not count as section headers, but the line preceding a
+// comment line selected on its own is a header of the comment line
+func (lm *simple_line_memory) select_headers() {
+	// determine minimum, i.e., top level, indentation
+	min_ind := -1
+	var i int
+	for i = range *lm.lines {
+		if (*lm.lines)[i].l_ind != -1 {
+			min_ind = (*lm.lines)[i].l_ind
+			break
+		}
+	}
+	par := lm.parents()
+	last := -1 // last line with headers
+	var h int
+	for i = range *lm.lines {
+		if (*lm.lines)[i].l_ind != -1 && (*lm.lines)[i].selected {
+			last = i
+			h = par[i]
+		} else if (*lm.lines)[i].selected_alone() {
+			h = par[i]
+			if h > last {
+				last = h
+			}
+		} else {
+			continue
+		}
+		// headers get the label of the first line selecting them
+		for ; h != -1; h = par[h] {
+			if !(*lm.lines)[h].selected {
+				(*lm.lines)[h].label = (*lm.lines)[i].label
+			}
+			(*lm.lines)[h].selected = true
+		}
+	}
+	// all lines up to the last line with headers are inside the top
+	// level section
+	for i = 0; i <= last; i++ {
+		if (*lm.lines)[i].l_ind != -1 {
+			(*lm.lines)[i].s_ind = min_ind
+		}
+	}
+}
+
+// prefix each memorized line with its ancestor lines, i.e., its headers,
+// without indentation, separated by the flatten separator
+// (empty lines stay empty)
+func (lm *simple_line_memory) flatten_lines() {
+	par := lm.parents()
+	flat := make([][]byte, len(*lm.lines)) // without line terminator
+	var text []byte
+	var i int
+	for i = range *lm.lines {
+		text = bytes.TrimSpace(trim_eol((*lm.lines)[i].data))
+		if par[i] != -1 && len(text) > 0 {
+			flat[i] = append(flat[i], flat[par[i]]...)
+			flat[i] = append(flat[i], lm.flatten_sep...)
+		}
+		flat[i] = append(flat[i], text...)
+	}
+	var data []byte
+	for i = range *lm.lines {
+		data = (*lm.lines)[i].data
+		(*lm.lines)[i].text = data
+		(*lm.lines)[i].data = append(flat[i], data[len(trim_eol(data)):]...)
+	}
+}
+
+// optionally add headers of selected sections, then print the contents of
+// a line collection and clear it
+// this works identically for generic implementations of the "memoryless",
+// "top level", and "enclosing" section algorithm variants
+func (lm *simple_line_memory) flush() (err error) {
+	prev_sect := false
+	in_sect := false
+	cont_sect := false
+	new_sect := false
+	// nothing to do if there are no saved lines
+	if lm.lines == nil {
+		return nil
+	}
+	// deselect sections with unwanted contents before adding headers
+	if lm.filter != nil {
+		lm.filter.apply(*lm.lines)
+	}
+	// optionally add header lines of selected sections
+	// this also changes the indentation depth to the top level
+	var l line
+	if lm.get_with_headers() {
+		lm.select_headers()
+	}
+	// lines are flattened after filtering and adding headers
+	if lm.flatten_sep != nil {
+		lm.flatten_lines()
+	}
+	// send lines to line printer
+	for _, l = range *lm.lines {
+		in_sect = l.s_ind > -1
+		// ignore lines with unspecified indentation level, but a
+		// comment line selected on its own may start a section
+		if l.l_ind == -1 {
+			err = lm.ign_output(&l).print_line(&l,
+				l.selected_alone() && !prev_sect, l.selected)
+			if err != nil {
+				break
+			}
+			if l.selected_alone() {
+				prev_sect = true
+			}
+			continue
+		}
+		cont_sect = in_sect && l.l_ind > l.s_ind
+		new_sect = in_sect && (!cont_sect || !prev_sect)
+		prev_sect = in_sect
+		err = lm.act.print_line(&l, new_sect, l.selected)
+		if err != nil {
+			break
+		}
+	}
+	lm.lines = nil
+	lm.buf.size = 0
+	return
+}
+
+// memoryless implementation of simple ("memoryless") section algorithm
+// this implementation differs from the generic one by not memorizing lines
+type memoryless_lm struct {
+	act   line_output // default output function
+	ign   line_output // output function for ignored lines
+	label []byte      // label of lines added next
+}
+
+// set the line printer for normal lines for memoryless implementation
+func (lm *memoryless_lm) set_act(lp line_output) {
+	lm.act = lp
+}
+
+// set the line printer for ignored lines for memoryless implementation
+func (lm *memoryless_lm) set_ign(lp line_output) {
+	lm.ign = lp
+}
+
+// memoryless implementation does not support adding headers
+func (lm *memoryless_lm) add_headers() {
+}
+
+// memoryless implementation does not support adding headers
+func (lm *memoryless_lm) get_with_headers() bool {
+	return false
+}
+
+// memoryless implementation does not support filtering sections, because
+// this requires looking at complete sections before printing them
+func (lm *memoryless_lm) set_filter(f *body_filter) {
+}
+
+// memoryless implementation does not memorize lines
+func (lm *memoryless_lm) set_limit(max int) {
+}
+
+// memoryless implementation does not support flattening lines, because
+// this requires memorized ancestor lines
+func (lm *memoryless_lm) set_flatten(sep string) {
+}
+
+// set the label of lines added next for memoryless implementation
+func (lm *memoryless_lm) set_label(label []byte) {
+	lm.label = label
+}
+
+// the simple section algorithm can be implemented "memoryless", i.e.,
+// without saving any lines, by just printing them
+func (lm *memoryless_lm) add(l *[]byte, nr uint64, l_ind int, matched bool, s_ind int) (int, error) {
+	var err error
+	in_sect := s_ind > -1
+	cont_sect := in_sect && l_ind > s_ind
+	new_sect := in_sect && !cont_sect
+	nl := line{l_ind: l_ind, nr: nr, data: *l, label: lm.label,
+		matched: matched}
+	if l_ind == -1 {
+		err = lm.ign.print_line(&nl, false, in_sect)
+	} else {
+		err = lm.act.print_line(&nl, new_sect, in_sect)
+	}
+	return s_ind, err
+}
+
+// print a comment line, which is selected inside a section, or on its own if
+// it matches the pattern
+func (lm *memoryless_lm) add_comment(l *[]byte, nr uint64, matched bool, s_ind int) (int, error) {
+	in_sect := s_ind > -1
+	nl := line{l_ind: -1, nr: nr, data: *l, label: lm.label,
+		comment: true, matched: matched}
+	return s_ind, lm.act.print_line(&nl, matched && !in_sect,
+		matched || in_sect)
+}
+
+// nothing to do for "memoryless" implementation, but required to implement
+// the line_memory interface
+func (lm *memoryless_lm) flush() error {
+	return nil
+}
+
+// a collection of lines with added information for the "top level"
+// section algorithm
+type top_level_lm struct {
+	simple_line_memory
+	matched    bool
+	sect_label []byte // label of the line selecting the top level section
+}
+
+// set the line printer for normal lines for "top level" implementation
+func (lm *top_level_lm) set_act(lp line_output) {
+	lm.simple_line_memory.act = lp
+}
+
+// set the line printer for ignored lines for "top level" implementation
+func (lm *top_level_lm) set_ign(lp line_output) {
+	lm.simple_line_memory.ign = lp
+}
+
+// all headers are already part of the selected "top level" section
+func (lm *top_level_lm) add_headers() {
+}
+
+// no special action required for headers, so always return false
+func (lm *top_level_lm) get_with_headers() bool {
+	return false
+}
+
+// set the filter to select sections based on their contents
+func (lm *top_level_lm) set_filter(f *body_filter) {
+	lm.simple_line_memory.filter = f
+}
+
+// set the maximum number of bytes of memorized lines
+func (lm *top_level_lm) set_limit(max int) {
+	lm.simple_line_memory.buf.max = max
+}
+
+// prefix printed lines with their ancestor lines for "top level" implementation
+func (lm *top_level_lm) set_flatten(sep string) {
+	lm.simple_line_memory.flatten_sep = []byte(sep)
+}
+
+// set the label of lines added next for "top level" implementation
+func (lm *top_level_lm) set_label(label []byte) {
+	lm.simple_line_memory.label = label
+}
+
+// add a line to the collection according to "top level" section rules
+func (lm *top_level_lm) add(l *[]byte, nr uint64, l_ind int, matched bool, s_ind int) (int, error) {
+	var err error
+	// the complete top level section is needed to filter by contents,
+	// thus lines are always saved
+	if lm.filter != nil {
+		_, err = lm.simple_line_memory.add(l, nr, l_ind, matched, s_ind)
+		if l_ind != -1 && l_ind == s_ind {
+			lm.select_section()
+		}
+		return s_ind, err
+	}
+	// as soon as the pattern has been matched, all lines can be sent
+	// to the line printer instead of saving a copy for later, unless
+	// lines are flattened, which needs their ancestors
+	if lm.matched && lm.flatten_sep != nil {
+		_, err = lm.simple_line_memory.add(l, nr, l_ind, matched, s_ind)
+		return s_ind, err
+	}
+	if lm.matched {
+		nl := line{l_ind: l_ind, nr: nr, data: *l, label: lm.label,
+			matched: matched}
+		if l_ind == -1 {
+			err = lm.ign.print_line(&nl, false, true)
+		} else {
+			err = lm.act.print_line(&nl, false, true)
+		}
+		return s_ind, err
+	}
+	// no pattern match yet, so save the line
+	_, err = lm.simple_line_memory.add(l, nr, l_ind, matched, s_ind)
+	if err != nil {
+		return s_ind, err
+	}
+	// ignored lines do not affect section meta data
+	if l_ind == -1 {
+		return s_ind, err
+	}
+	// only pattern match can affect section meta data
+	if l_ind != s_ind {
+		return s_ind, err
+	}
+	min_ind, err := lm.print_saved()
+	if min_ind == -1 {
+		return s_ind, err
+	}
+	return min_ind, err
+}
+
+// a comment line matching the pattern selects the current top level section
+func (lm *top_level_lm) add_comment(l *[]byte, nr uint64, matched bool, s_ind int) (int, error) {
+	var err error
+	if lm.filter != nil {
+		_, err = lm.simple_line_memory.add_comment(l, nr, matched, s_ind)
+		if matched {
+			lm.select_section()
+		}
+		return s_ind, err
+	}
+	if lm.matched && lm.flatten_sep == nil {
+		nl := line{l_ind: -1, nr: nr, data: *l, label: lm.label,
+			comment: true, matched: matched}
+		return s_ind, lm.act.print_line(&nl, false, true)
+	}
+	_, err = lm.simple_line_memory.add_comment(l, nr, matched, s_ind)
+	if err != nil || !matched || lm.matched {
+		return s_ind, err
+	}
+	// a comment line preceding the top level section is selected on
+	// its own when flushing
+	var i int
+	for i = range *lm.lines {
+		if (*lm.lines)[i].l_ind != -1 {
+			return lm.print_saved()
+		}
+	}
+	return s_ind, err
+}
+
+// send all saved lines to the appropriate line printer marked as "inside a
+// section", and return the section indentation level, i.e., the indentation
+// level of the first non-ignored line
+// flattened lines are only marked as "inside a section" and printed when
+// flushing
+func (lm *top_level_lm) print_saved() (int, error) {
+	var err error
+	lm.select_section()
+	min_ind := -1
+	var sl *line
+	var new_sect bool
+	var i int
+	if lm.flatten_sep != nil {
+		return lm.select_all(), err
+	}
+	for i = range *lm.lines {
+		sl = &(*lm.lines)[i]
+		// section has indentation level of first non-ignored line
+		if sl.l_ind == -1 {
+			err = lm.ign_output(sl).print_line(sl, false, true)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		if min_ind == -1 {
+			min_ind = sl.l_ind
+			new_sect = true
+		}
+		err = lm.act.print_line(sl, new_sect, true)
+		if err != nil {
+			break
+		}
+		new_sect = false
+	}
+	// all saved lines have been sent to a line printer
+	lm.lines = nil
+	lm.buf.size = 0
+	return min_ind, err
+}
+
+// the first match selects the top level section, the lines memorized so
+// far get the label of the matched line
+func (lm *top_level_lm) select_section() {
+	if lm.matched {
+		return
+	}
+	lm.matched = true
+	lm.sect_label = lm.label
+	var i int
+	for i = range *lm.lines {
+		(*lm.lines)[i].label = lm.label
+	}
+}
+
+// mark all saved lines as one section with the indentation level of the
+// first non-ignored line and the label of the line selecting it, and
+// return this section indentation level
+func (lm *top_level_lm) select_all() int {
+	min_ind := -1
+	var i int
+	for i = range *lm.lines {
+		if (*lm.lines)[i].l_ind != -1 {
+			min_ind = (*lm.lines)[i].l_ind
+			break
+		}
+	}
+	for i = range *lm.lines {
+		(*lm.lines)[i].s_ind = min_ind
+		(*lm.lines)[i].selected = true
+		(*lm.lines)[i].label = lm.sect_label
+	}
+	return min_ind
+}
+
+// the "top level" section algorithm variant allows simpler handling
+// of saved lines than the generic .flush() implementation
+func (lm *top_level_lm) flush() (err error) {
+	// with a filter, all lines of a matched top level section are marked
+	// as one section, and the generic .flush() method applies the filter
+	if lm.filter != nil && lm.matched && lm.lines != nil {
+		lm.select_all()
+	}
+	// the last top level section is over, we do not have a match yet
+	lm.matched = false
+	if lm.lines == nil {
+		return
+	}
+	if lm.filter != nil {
+		return lm.simple_line_memory.flush()
+	}
+	if lm.flatten_sep != nil {
+		lm.flatten_lines()
+	}
+	// send all saved lines to the appropriate line printer marked as
+	// "outside of a section", except for matched comment lines and
+	// flattened lines of a matched section
+	new_sect := true
+	var l line
+	for _, l = range *lm.lines {
+		if l.l_ind == -1 {
+			err = lm.ign_output(&l).print_line(&l, l.selected_alone(), l.selected)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		err = lm.act.print_line(&l, new_sect, l.selected)
+		if err != nil {
+			break
+		}
+		new_sect = false
+	}
+	lm.lines = nil
+	lm.buf.size = 0
+	return
+}
+
+// a collection of lines with added information for the "enclosing"
+// section algorithm
+// lines are memorized until the top level section is complete, because a
+// later match may select the enclosing section starting at any of its
+// lines, but as soon as the complete top level section is selected, lines
+// are printed without memorizing them
+type enclosing_lm struct {
+	simple_line_memory
+	matched bool // is the complete top level section selected?
+}
+
+// set the line printer for normal lines for "enclosing" implementation
+func (lm *enclosing_lm) set_act(lp line_output) {
+	lm.simple_line_memory.act = lp
+}
+
+// set the line printer for normal lines for "enclosing" implementation
+func (lm *enclosing_lm) set_ign(lp line_output) {
+	lm.simple_line_memory.ign = lp
+}
+
+// also select headers in addition to enclosing section
+func (lm *enclosing_lm) add_headers() {
+	lm.simple_line_memory.with_headers = true
+}
+
+// query value of with_headers flag
+func (lm *enclosing_lm) get_with_headers() bool {
+	return lm.simple_line_memory.with_headers
+}
+
+// set the filter to select sections based on their contents
+func (lm *enclosing_lm) set_filter(f *body_filter) {
+	lm.simple_line_memory.filter = f
+}
+
+// set the maximum number of bytes of memorized lines
+func (lm *enclosing_lm) set_limit(max int) {
+	lm.simple_line_memory.buf.max = max
+}
+
+// prefix printed lines with their ancestor lines for "enclosing" implementation
+func (lm *enclosing_lm) set_flatten(sep string) {
+	lm.simple_line_memory.flatten_sep = []byte(sep)
+}
+
+// set the label of lines added next for "enclosing" implementation
+func (lm *enclosing_lm) set_label(label []byte) {
+	lm.simple_line_memory.label = label
+}
+
+// add a line to the collection according to "enclosing" section rules
+func (lm *enclosing_lm) add(l *[]byte, nr uint64, l_ind int, matched bool, s_ind int) (int, error) {
+	var err error
+	// all lines following a selected top level line are part of its
+	// section
+	if lm.matched {
+		nl := line{l_ind: l_ind, nr: nr, data: *l, label: lm.label,
+			matched: matched}
+		if l_ind == -1 {
+			err = lm.ign.print_line(&nl, false, true)
+		} else {
+			err = lm.act.print_line(&nl, false, true)
+		}
+		return s_ind, err
+	}
+	_, err = lm.simple_line_memory.add(l, nr, l_ind, matched, s_ind)
+	if err != nil {
+		return s_ind, err
+	}
+	// ignored lines do not affect section meta data
+	if l_ind == -1 {
+		return s_ind, err
+	}
+	// only pattern match can affect section meta data
+	if l_ind != s_ind {
+		return s_ind, err
+	}
+	// extend a new section from the last preceding line with lower
+	// indentation level to the new line that was just added
+	nr_lines := len(*lm.lines)
+	// find section start: set i to number of possible section start
+	i := nr_lines - 1
+	for ; i > 0; i-- {
+		if (*lm.lines)[i].l_ind != -1 && (*lm.lines)[i].l_ind < s_ind {
+			break
+		}
+	}
+	// determine section indentation level
+	if (*lm.lines)[i].l_ind != -1 {
+		// line in position i starts the section
+		s_ind = (*lm.lines)[i].l_ind
+	} else {
+		// line matching pattern starts the section
+		return s_ind, lm.print_top_level()
+	}
+	// mark lines comprising section with newly found indentation level
+	// and the label of the matched line
+	for ; i < nr_lines; i++ {
+		(*lm.lines)[i].s_ind = s_ind
+		(*lm.lines)[i].selected = true
+		(*lm.lines)[i].label = lm.label
+	}
+	return s_ind, lm.print_top_level()
+}
+
+// print the memorized lines if the top level line is selected, i.e., the
+// complete top level section is selected, unless filtering or flattening
+// needs the complete section
+func (lm *enclosing_lm) print_top_level() error {
+	if lm.filter != nil || lm.flatten_sep != nil {
+		return nil
+	}
+	var l *line
+	var i int
+	for i = range *lm.lines {
+		l = &(*lm.lines)[i]
+		if l.l_ind == -1 {
+			continue
+		}
+		if !l.selected {
+			return nil
+		}
+		lm.matched = true
+		return lm.simple_line_memory.flush()
+	}
+	return nil
+}
+
+// a comment line matching the pattern outside of a section selects the
+// section started by the preceding line
+func (lm *enclosing_lm) add_comment(l *[]byte, nr uint64, matched bool, s_ind int) (int, error) {
+	if lm.matched {
+		nl := line{l_ind: -1, nr: nr, data: *l, label: lm.label,
+			comment: true, matched: matched}
+		return s_ind, lm.act.print_line(&nl, false, true)
+	}
+	_, err := lm.simple_line_memory.add_comment(l, nr, matched, s_ind)
+	if err != nil || !matched || s_ind > -1 {
+		return s_ind, err
+	}
+	s_ind = lm.select_comment_parents(1, s_ind)
+	return s_ind, lm.print_top_level()
+}
+
+// use .flush() method from the generic implementation of the simple
+// ("memoryless") section algorithm line memory for "enclosing"
+func (lm *enclosing_lm) flush() (err error) {
+	lm.matched = false
+	return lm.simple_line_memory.flush()
+}
+
+// a collection of lines with added information for the "parent" section
+// algorithm, which generalizes the "enclosing" algorithm to climb up a
+// given number of indentation levels
+type parent_lm struct {
+	simple_line_memory
+	levels int // number of indentation levels to climb up
+}
+
+// set the line printer for normal lines for "parent" implementation
+func (lm *parent_lm) set_act(lp line_output) {
+	lm.simple_line_memory.act = lp
+}
+
+// set the line printer for ignored lines for "parent" implementation
+func (lm *parent_lm) set_ign(lp line_output) {
+	lm.simple_line_memory.ign = lp
+}
+
+// also select headers in addition to parent section
+func (lm *parent_lm) add_headers() {
+	lm.simple_line_memory.with_headers = true
+}
+
+// query value of with_headers flag
+func (lm *parent_lm) get_with_headers() bool {
+	return lm.simple_line_memory.with_headers
+}
+
+// set the filter to select sections based on their contents
+func (lm *parent_lm) set_filter(f *body_filter) {
+	lm.simple_line_memory.filter = f
+}
+
+// set the maximum number of bytes of memorized lines
+func (lm *parent_lm) set_limit(max int) {
+	lm.simple_line_memory.buf.max = max
+}
+
+// prefix printed lines with their ancestor lines for "parent" implementation
+func (lm *parent_lm) set_flatten(sep string) {
+	lm.simple_line_memory.flatten_sep = []byte(sep)
+}
+
+// set the label of lines added next for "parent" implementation
+func (lm *parent_lm) set_label(label []byte) {
+	lm.simple_line_memory.label = label
+}
+
+// add a line to the collection according to "parent" section rules
+func (lm *parent_lm) add(l *[]byte, nr uint64, l_ind int, matched bool, s_ind int) (int, error) {
+	var err error
+	_, err = lm.simple_line_memory.add(l, nr, l_ind, matched, s_ind)
+	if err != nil {
+		return s_ind, err
+	}
+	// ignored lines do not affect section meta data
+	if l_ind == -1 {
+		return s_ind, err
+	}
+	// only pattern match can affect section meta data
+	if l_ind != s_ind {
+		return s_ind, err
+	}
+	// climb up the requested number of levels, stopping at the top level
+	nr_lines := len(*lm.lines)
+	start := nr_lines - 1
+	i := start
+	var lvl int
+	for lvl = 0; lvl < lm.levels; lvl++ {
+		for i--; i >= 0; i-- {
+			if (*lm.lines)[i].l_ind != -1 && (*lm.lines)[i].l_ind < s_ind {
+				break
+			}
+		}
+		if i < 0 {
+			break
+		}
+		start = i
+		s_ind = (*lm.lines)[i].l_ind
+	}
+	// mark lines comprising section with newly found indentation level
+	// and the label of the matched line
+	for i = start; i < nr_lines; i++ {
+		(*lm.lines)[i].s_ind = s_ind
+		(*lm.lines)[i].selected = true
+		(*lm.lines)[i].label = lm.label
+	}
+	return s_ind, err
+}
+
+// a comment line matching the pattern outside of a section climbs up from
+// the preceding line, which counts as the first level
+func (lm *parent_lm) add_comment(l *[]byte, nr uint64, matched bool, s_ind int) (int, error) {
+	_, err := lm.simple_line_memory.add_comment(l, nr, matched, s_ind)
+	if err != nil || !matched || s_ind > -1 {
+		return s_ind, err
+	}
+	return lm.select_comment_parents(lm.levels, s_ind), err
+}
+
+// use .flush() method from the generic implementation of the simple
+// ("memoryless") section algorithm line memory for "parent"
+func (lm *parent_lm) flush() (err error) {
+	return lm.simple_line_memory.flush()
+}
+
+// a collection of lines with added information for the "siblings" section
+// algorithm, which additionally selects sections at the same indentation
+// level inside the same enclosing section as a matched section
+type siblings_lm struct {
+	simple_line_memory
+	preceding bool   // also select preceding sibling sections?
+	following int    // number of following sibling sections, -1 for all
+	sib_ind   int    // indentation level of current siblings, 0 for none
+	remaining int    // number of following sibling sections still to select
+	sib_label []byte // label of the line selecting the current siblings
+}
+
+// set the line printer for normal lines for "siblings" implementation
+func (lm *siblings_lm) set_act(lp line_output) {
+	lm.simple_line_memory.act = lp
+}
+
+// set the line printer for ignored lines for "siblings" implementation
+func (lm *siblings_lm) set_ign(lp line_output) {
+	lm.simple_line_memory.ign = lp
+}
+
+// also select headers in addition to sibling sections
+func (lm *siblings_lm) add_headers() {
+	lm.simple_line_memory.with_headers = true
+}
+
+// query value of with_headers flag
+func (lm *siblings_lm) get_with_headers() bool {
+	return lm.simple_line_memory.with_headers
+}
+
+// set the filter to select sections based on their contents
+func (lm *siblings_lm) set_filter(f *body_filter) {
+	lm.simple_line_memory.filter = f
+}
+
+// set the maximum number of bytes of memorized lines
+func (lm *siblings_lm) set_limit(max int) {
+	lm.simple_line_memory.buf.max = max
+}
+
+// prefix printed lines with their ancestor lines for "siblings" implementation
+func (lm *siblings_lm) set_flatten(sep string) {
+	lm.simple_line_memory.flatten_sep = []byte(sep)
+}
+
+// set the label of lines added next for "siblings" implementation
+func (lm *siblings_lm) set_label(label []byte) {
+	lm.simple_line_memory.label = label
+}
+
+// add a line to the collection according to "siblings" section rules
+func (lm *siblings_lm) add(l *[]byte, nr uint64, l_ind int, matched bool, s_ind int) (int, error) {
+	var err error
+	_, err = lm.simple_line_memory.add(l, nr, l_ind, matched, s_ind)
+	if err != nil {
+		return s_ind, err
+	}
+	// ignored lines do not affect section meta data
+	if l_ind == -1 {
+		return s_ind, err
+	}
+	nr_lines := len(*lm.lines)
+	// a pattern match starts a new group of sibling sections
+	if l_ind == s_ind {
+		lm.sib_ind = 0
+		// find the enclosing section, top level sections have none,
+		// and thus no siblings
+		i := nr_lines - 2
+		for ; i >= 0; i-- {
+			if (*lm.lines)[i].l_ind != -1 && (*lm.lines)[i].l_ind < l_ind {
+				break
+			}
+		}
+		if i < 0 {
+			return s_ind, err
+		}
+		lm.sib_ind = l_ind
+		lm.remaining = lm.following
+		lm.sib_label = lm.label
+		// all lines after the enclosing section header belong to
+		// preceding sibling sections
+		if lm.preceding {
+			for i++; i < nr_lines; i++ {
+				(*lm.lines)[i].s_ind = l_ind
+				(*lm.lines)[i].selected = true
+				(*lm.lines)[i].label = lm.label
+			}
+		}
+		return s_ind, err
+	}
+	// lines inside a section and lines outside of a group of siblings
+	// do not affect section meta data
+	if s_ind > -1 || lm.sib_ind == 0 {
+		return s_ind, err
+	}
+	// a following sibling starts a new section, labeled like the
+	// matched section, which includes the lines inside the new section
+	if l_ind == lm.sib_ind && lm.remaining != 0 {
+		if lm.remaining > 0 {
+			lm.remaining--
+		}
+		lm.label = lm.sib_label
+		(*lm.lines)[nr_lines-1].s_ind = l_ind
+		(*lm.lines)[nr_lines-1].selected = true
+		(*lm.lines)[nr_lines-1].label = lm.label
+		return l_ind, err
+	}
+	// any other line ends the group of siblings
+	lm.sib_ind = 0
+	return s_ind, err
+}
+
+// a comment line has no siblings, it is selected on its own if it matches
+// the pattern outside of a section
+func (lm *siblings_lm) add_comment(l *[]byte, nr uint64, matched bool, s_ind int) (int, error) {
+	return lm.simple_line_memory.add_comment(l, nr, matched, s_ind)
+}
+
+// use .flush() method from the generic implementation of the simple
+// ("memoryless") section algorithm line memory for "siblings"
+func (lm *siblings_lm) flush() (err error) {
+	return lm.simple_line_memory.flush()
+}
+
+// a possible section header, together with the lines following it, which
+// wait for the possible header to be printed
+type header_candidate struct {
+	l       line   // the possible header line
+	printed bool   // has the line been printed as a header?
+	pending []line // ignored lines and lines not selected following it
+}
+
+// streaming implementation of the simple ("memoryless") section algorithm
+// that also selects section headers
+// instead of memorizing all lines of a top level section, only the chain
+// of possible headers of the current line is memorized, together with
+// lines following a possible header that has not been printed yet, and
+// the lines of the current section when filtering sections by contents
+// lines that are neither selected nor headers are only memorized if they
+// are printed, i.e., with --omit
+type headers_lm struct {
+	act        line_output        // default output function
+	ign        line_output        // output function for ignored lines
+	unselected bool               // print lines that are not selected?
+	started    bool               // has the top level section started?
+	min_ind    int                // indentation level of top level section
+	chain      []header_candidate // possible headers of the current line
+	filter     *body_filter       // filter sections by their contents
+	sect       []line             // lines of the current section to filter
+	buf        buffer_limit       // limit memory used for memorized lines
+	label      []byte             // label of lines added next
+}
+
+// set the line printer for normal lines for streaming "headers"
+func (lm *headers_lm) set_act(lp line_output) {
+	lm.act = lp
+}
+
+// set the line printer for ignored lines for streaming "headers"
+func (lm *headers_lm) set_ign(lp line_output) {
+	lm.ign = lp
+}
+
+// streaming "headers" implementation always adds headers
+func (lm *headers_lm) add_headers() {
+}
+
+// streaming "headers" implementation always adds headers
+func (lm *headers_lm) get_with_headers() bool {
+	return true
+}
+
+// set the filter to select sections based on their contents
+func (lm *headers_lm) set_filter(f *body_filter) {
+	lm.filter = f
+}
+
+// set the maximum number of bytes of memorized lines
+func (lm *headers_lm) set_limit(max int) {
+	lm.buf.max = max
+}
+
+// streaming "headers" implementation does not support flattening lines,
+// because this requires memorized ancestor lines
+func (lm *headers_lm) set_flatten(sep string) {
+}
+
+// set the label of lines added next for streaming "headers"
+func (lm *headers_lm) set_label(label []byte) {
+	lm.label = label
+}
+
+// memorize a copy of the line data
+func (lm *headers_lm) keep(l *line) error {
+	err := lm.buf.grow(len(l.data))
+	if err != nil {
+		return err
+	}
+	l.data = append([]byte(nil), l.data...)
+	return nil
+}
+
+// select the output for a line, comment lines are printed like normal lines
+func (lm *headers_lm) output(l *line) line_output {
+	if l.l_ind == -1 && !l.comment {
+		return lm.ign
+	}
+	return lm.act
+}
+
+// print the lines following a possible header
+func (lm *headers_lm) print_pending(hc *header_candidate) (err error) {
+	var i int
+	var pl *line
+	for i = range hc.pending {
+		pl = &hc.pending[i]
+		err = lm.output(pl).print_line(pl, false, pl.selected)
+		if err != nil {
+			return
+		}
+		lm.buf.shrink(len(pl.data))
+	}
+	hc.pending = nil
+	return
+}
+
+// add a line according to simple ("memoryless") rules, printing selected
+// lines together with all not yet printed headers
+func (lm *headers_lm) add(l *[]byte, nr uint64, l_ind int, matched bool, s_ind int) (int, error) {
+	nl := line{
+		l_ind:    l_ind,
+		s_ind:    s_ind,
+		selected: s_ind > -1,
+		nr:       nr,
+		data:     *l,
+		label:    lm.label,
+		matched:  matched,
+	}
+	if lm.filter != nil {
+		return s_ind, lm.add_filtered(nl)
+	}
+	return s_ind, lm.add_line(nl)
+}
+
+// add a comment line, which waits for a possible header to be printed like
+// an ignored line, but a comment line matching the pattern outside of a
+// section is printed together with the preceding line and its headers
+func (lm *headers_lm) add_comment(l *[]byte, nr uint64, matched bool, s_ind int) (int, error) {
+	nl := line{
+		l_ind:    -1,
+		s_ind:    s_ind,
+		selected: s_ind > -1 || matched,
+		nr:       nr,
+		data:     *l,
+		label:    lm.label,
+		comment:  true,
+		matched:  matched,
+	}
+	if lm.filter != nil {
+		return s_ind, lm.add_filtered(nl)
+	}
+	return s_ind, lm.add_line(nl)
+}
+
+// memorize the lines of a section until it is complete, then add them
+// deselected if they do not satisfy the filter
+// (a section ends like in the body_filter.apply() method)
+func (lm *headers_lm) add_filtered(nl line) (err error) {
+	ends := !nl.selected || (nl.l_ind != -1 && nl.l_ind <= nl.s_ind) ||
+		nl.selected_alone()
+	if ends && lm.sect != nil {
+		err = lm.end_section()
+		if err != nil {
+			return
+		}
+	}
+	if !nl.selected {
+		return lm.add_line(nl)
+	}
+	err = lm.keep(&nl)
+	if err != nil {
+		return
+	}
+	lm.sect = append(lm.sect, nl)
+	return
+}
+
+// apply the filter to the completed section and add its lines
+func (lm *headers_lm) end_section() (err error) {
+	lines := lm.sect
+	lm.sect = nil
+	passed := lm.filter.check(lines)
+	if passed {
+		lm.filter.passed = true
+	}
+	var i int
+	for i = range lines {
+		lm.buf.shrink(len(lines[i].data))
+		if !passed {
+			lines[i].selected = false
+			lines[i].s_ind = -1
+		}
+		err = lm.add_line(lines[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// add a line to the chain of possible headers, or to the lines waiting for
+// a possible header, printing selected lines together with their headers
+func (lm *headers_lm) add_line(nl line) (err error) {
+	var hc *header_candidate
+	last := len(lm.chain) - 1
+	// lines without indentation level wait for a possible header to be
+	// printed, but a comment line selected on its own is printed together
+	// with the preceding line and its headers
+	if nl.l_ind == -1 {
+		if nl.selected_alone() {
+			err = lm.print_chain(nl.label)
+			if err != nil {
+				return
+			}
+			return lm.act.print_line(&nl, last == -1, true)
+		}
+		if last == -1 || lm.chain[last].printed {
+			return lm.output(&nl).print_line(&nl, false, nl.selected)
+		}
+		err = lm.keep(&nl)
+		if err != nil {
+			return
+		}
+		lm.chain[last].pending = append(lm.chain[last].pending, nl)
+		return
+	}
+	if !lm.started {
+		lm.started = true
+		lm.min_ind = nl.l_ind
+	}
+	// lines at the same or a deeper indentation level cannot be headers
+	// of the current line, lines following them either keep waiting for
+	// a preceding possible header, or are printed
+	for ; last > -1 && lm.chain[last].l.l_ind >= nl.l_ind; last-- {
+		hc = &lm.chain[last]
+		if !hc.printed {
+			err = lm.drop_candidate(last)
+			if err != nil {
+				return
+			}
+		}
+		lm.chain = lm.chain[:last]
+	}
+	// the current line is a possible header of following lines
+	err = lm.keep(&nl)
+	if err != nil {
+		return
+	}
+	lm.chain = append(lm.chain, header_candidate{l: nl})
+	if !nl.selected {
+		return
+	}
+	// a selected line is printed together with all its headers
+	return lm.print_chain(nl.label)
+}
+
+// handle a possible header at the given chain position, which turned out
+// not to be a header, the line itself is only printed with --omit
+func (lm *headers_lm) drop_candidate(i int) (err error) {
+	hc := &lm.chain[i]
+	if !lm.unselected {
+		lm.buf.shrink(len(hc.l.data))
+	}
+	if i > 0 && !lm.chain[i-1].printed {
+		prev := &lm.chain[i-1]
+		if lm.unselected {
+			prev.pending = append(prev.pending, hc.l)
+		}
+		prev.pending = append(prev.pending, hc.pending...)
+		hc.pending = nil
+		return
+	}
+	if lm.unselected {
+		err = lm.act.print_line(&hc.l, false, false)
+		if err != nil {
+			return
+		}
+		lm.buf.shrink(len(hc.l.data))
+	}
+	return lm.print_pending(hc)
+}
+
+// print all lines of the chain of possible headers, which are not printed
+// yet, with the label of the line selecting them, and the lines following
+// them
+func (lm *headers_lm) print_chain(label []byte) (err error) {
+	var hc *header_candidate
+	var i int
+	for i = range lm.chain {
+		hc = &lm.chain[i]
+		if hc.printed {
+			continue
+		}
+		hc.l.label = label
+		err = lm.act.print_line(&hc.l, hc.l.l_ind <= lm.min_ind, true)
+		if err != nil {
+			return
+		}
+		lm.buf.shrink(len(hc.l.data))
+		hc.printed = true
+		hc.l.data = nil
+		err = lm.print_pending(hc)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// the top level section is complete, thus possible headers that have not
+// been printed are not headers, but the lines following them still need
+// to be printed
+func (lm *headers_lm) flush() (err error) {
+	if lm.sect != nil {
+		err = lm.end_section()
+		if err != nil {
+			return
+		}
+	}
+	var i int
+	for i = len(lm.chain) - 1; i >= 0; i-- {
+		if lm.chain[i].printed {
+			continue
+		}
+		err = lm.drop_candidate(i)
+		if err != nil {
+			return
+		}
+	}
+	lm.chain = nil
+	lm.started = false
+	lm.buf.size = 0
+	return
+}
+
+// one chunk of data read by a follow_reader
+type follow_chunk struct {
+	data []byte
+	err  error
+}
+
+// error returned once by a follow_reader when no new data arrived for
+// some time, reading can continue afterwards
+var err_idle = errors.New("idle input")
+
+// reader following a growing file, similar to "tail -F", i.e., after
+// reaching the end of a regular file, it waits for more data, re-opens
+// the file after rotation, and starts from the beginning after truncation
+// err_idle is returned when no new data arrived for some time
+type follow_reader struct {
+	name    string            // file name used to detect rotation
+	f       *os.File          // currently followed file
+	off     int64             // read offset inside the current file
+	regular bool              // is the followed file a regular file?
+	idle    time.Duration     // return err_idle after this time without data
+	chunks  chan follow_chunk // data read in the background
+	pending []byte            // data received, but not yet returned
+	err     error             // error received, to be returned after data
+	waited  bool              // has err_idle been returned since the last data?
+}
+
+// create a follow_reader for an open file, the name is used to detect
+// file rotation, an empty name disables rotation detection
+func new_follow_reader(f *os.File, name string, idle time.Duration) *follow_reader {
+	fr := &follow_reader{
+		name:   name,
+		f:      f,
+		idle:   idle,
+		chunks: make(chan follow_chunk),
+	}
+	fi, err := f.Stat()
+	fr.regular = err == nil && fi.Mode().IsRegular()
+	go fr.read_loop()
+	return fr
+}
+
+// check followed file for truncation and rotation
+func (fr *follow_reader) check() {
+	fi, err := fr.f.Stat()
+	if err != nil {
+		return
+	}
+	if fi.Size() < fr.off {
+		_, err = fr.f.Seek(0, io.SeekStart)
+		if err == nil {
+			fr.off = 0
+		}
+	}
+	if fr.name == "" {
+		return
+	}
+	// a missing file may re-appear later
+	nfi, err := os.Stat(fr.name)
+	if err != nil || os.SameFile(fi, nfi) {
+		return
+	}
+	nf, err := os.Open(fr.name)
+	if err != nil {
+		return
+	}
+	fr.f.Close()
+	fr.f = nf
+	fr.off = 0
+}
+
+// read data in the background, waiting for more data at the end of
+// a regular file
+func (fr *follow_reader) read_loop() {
+	var buf []byte
+	var n int
+	var err error
+	for {
+		buf = make([]byte, FOLLOW_BUF_SIZE)
+		n, err = fr.f.Read(buf)
+		fr.off += int64(n)
+		if n > 0 {
+			fr.chunks <- follow_chunk{data: buf[:n]}
+		}
+		if err == io.EOF && fr.regular {
+			time.Sleep(FOLLOW_POLL)
+			fr.check()
+			continue
+		}
+		if err != nil {
+			fr.chunks <- follow_chunk{err: err}
+			close(fr.chunks)
+			return
+		}
+	}
+}
+
+// return data read in the background, or err_idle if no data arrives in
+// time, the next call waits for data without a time limit
+func (fr *follow_reader) Read(b []byte) (int, error) {
+	if len(fr.pending) == 0 && fr.err == nil {
+		var c follow_chunk
+		var ok bool
+		if fr.idle > 0 && !fr.waited {
+			timer := time.NewTimer(fr.idle)
+			select {
+			case c, ok = <-fr.chunks:
+				timer.Stop()
+			case <-timer.C:
+				fr.waited = true
+				return 0, err_idle
+			}
+		} else {
+			c, ok = <-fr.chunks
+		}
+		if !ok {
+			return 0, io.EOF
+		}
+		fr.pending, fr.err = c.data, c.err
+		fr.waited = false
+	}
+	if len(fr.pending) == 0 {
+		return 0, fr.err
+	}
+	n := copy(b, fr.pending)
+	fr.pending = fr.pending[n:]
+	return n, nil
+}
+
+// print error with prefix
+func print_err(err error) {
+	log.SetPrefix(PROG + ": error: ")
+	log.Print(err)
+}
+
+// print short usage information
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [OPTION...] PATTERN [FILE...]\n", PROG)
+}
+
+// print error with prefix, print short usage info, then exit with code 2
+func usage_err(err error) {
+	log.SetPrefix(PROG + ": error: ")
+	log.Print(err)
+	usage(os.Stderr)
+	fmt.Fprintf(os.Stderr, "Try '%s --help' for more information\n", PROG)
+	os.Exit(2)
+}
+
+// print help
+func help() {
+	version()
+	fmt.Println("")
+	fmt.Println(PROG, DESC)
+	usage(os.Stdout)
+	fmt.Printf("  or:  %s --merge [OPTION...] BASE OVERLAY...\n", PROG)
+	fmt.Println("Options:")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
+}
+
+// print version and copyright information
+func version() {
+	fmt.Println(PROG, "version", VERSION)
+	fmt.Println(COPYRIGHT)
+}
+
+// format the settings of a profile as command line options
+func (pr *profile) String() string {
+	var opts []string
+	var ps profile_setting
+	for _, ps = range pr.settings {
+		if ps.value == "true" {
+			opts = append(opts, "--"+ps.name)
+		} else {
+			opts = append(opts, "--"+ps.name+" "+shell_quote(ps.value))
+		}
+	}
+	return strings.Join(opts, " ")
+}
+
+// quote a string for use as a single shell word, if needed
+func shell_quote(s string) string {
+	if s != "" && strings.Trim(s, SHELL_SAFE) == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// print all available profiles together with their option settings
+func list_profiles() {
+	var i int
+	for i = range profiles {
+		fmt.Printf("%s: %s\n", profiles[i].name, profiles[i].String())
+	}
+}
+
+// find a profile by name
+func find_profile(name string) *profile {
+	var i int
+	for i = range profiles {
+		if profiles[i].name == name {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// add a profile, replacing a profile of the same name
+func define_profile(pr profile) {
+	old := find_profile(pr.name)
+	if old != nil {
+		*old = pr
+	} else {
+		profiles = append(profiles, pr)
+	}
+}
+
+// apply option settings to all options not already set, either explicitly
+// on the command line or via settings applied previously, an option already
+// set prevents applying settings of related options, too
+func apply_settings(settings []profile_setting) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		name := canonical_option(f.Name)
+		given[name] = true
+		var r string
+		for _, r = range related_options[name] {
+			given[r] = true
+		}
+	})
+	var ps profile_setting
+	for _, ps = range settings {
+		if given[canonical_option(ps.name)] {
+			continue
+		}
+		err := flag.Set(ps.name, ps.value)
+		if err != nil {
+			return fmt.Errorf("option '%s': %v", ps.name, err)
+		}
+	}
+	return nil
+}
+
+// apply the settings of the named profile to all options not already set
+func apply_profile(name string) error {
+	pr := find_profile(name)
+	if pr == nil {
+		return fmt.Errorf("unknown profile '%s'", name)
+	}
+	err := apply_settings(pr.settings)
+	if err != nil {
+		return fmt.Errorf("profile '%s': %v", name, err)
+	}
+	return nil
+}
+
+// check if a command line flag is a boolean option without argument
+func is_bool_flag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// convert arguments using command line syntax into option settings
+func parse_settings(args []string) (settings []profile_setting, err error) {
+	var arg, name, value string
+	var has_value bool
+	var f *flag.Flag
+	for i := 0; i < len(args); i++ {
+		arg = args[i]
+		if len(arg) < 2 || arg[0] != '-' {
+			return nil, fmt.Errorf("unexpected argument '%s'", arg)
+		}
+		name = strings.TrimLeft(arg, "-")
+		value = ""
+		has_value = false
+		if eq := strings.IndexByte(name, '='); eq > -1 {
+			name, value, has_value = name[:eq], name[eq+1:], true
+		}
+		f = flag.Lookup(name)
+		if f == nil {
+			return nil, fmt.Errorf("unknown option '%s'", arg)
+		}
+		if !has_value {
+			if is_bool_flag(f) {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				return nil, fmt.Errorf("option '%s' needs an argument",
+					arg)
+			}
+		}
+		settings = append(settings, profile_setting{f.Name, value})
+	}
+	return
+}
+
+// determine the path of the default configuration file
+func config_path() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, PROG, CONFIG_NAME)
+}
+
+// read a configuration file comprising default option settings and
+// profile definitions, a missing configuration file is not an error unless
+// it must exist
+//
+// each line contains one option, optionally followed by white space and
+// the option argument, which extends to the end of the line, a line of
+// the form "[profile NAME]" starts the definition of a profile, empty
+// lines and lines starting with "#" are ignored
+func read_config(path string, must_exist bool) (defaults []profile_setting, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if !must_exist && errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	var cur *profile
+	var ps []profile_setting
+	var l, opt, arg string
+	l_nr := 0
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l_nr++
+		l = strings.TrimSpace(s.Text())
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(l, "[profile ") && strings.HasSuffix(l, "]") {
+			if cur != nil {
+				define_profile(*cur)
+			}
+			cur = &profile{
+				name: strings.TrimSpace(l[len("[profile ") : len(l)-1]),
+			}
+			if cur.name == "" {
+				return nil, fmt.Errorf("%s:%d: profile name is missing",
+					path, l_nr)
+			}
+			continue
+		}
+		opt, arg = l, ""
+		if i := strings.IndexAny(l, " \t"); i > -1 {
+			opt, arg = l[:i], strings.TrimLeft(l[i:], " \t")
+		}
+		if arg == "" {
+			ps, err = parse_settings([]string{opt})
+		} else {
+			ps, err = parse_settings([]string{opt, arg})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, l_nr, err)
+		}
+		if cur == nil {
+			defaults = append(defaults, ps...)
+			continue
+		}
+		if ps[0].name == "profile" {
+			return nil, fmt.Errorf("%s:%d: profiles cannot be nested",
+				path, l_nr)
+		}
+		cur.settings = append(cur.settings, ps...)
+	}
+	if cur != nil {
+		define_profile(*cur)
+	}
+	err = s.Err()
+	return
+}
+
+// apply default option settings from the SECTION_OPTIONS environment
+// variable and the configuration file, command line options and the profile
+// given on the command line, if any, take precedence over the environment
+// variable, which takes precedence over the configuration file, an
+// explicitly given configuration file must exist
+func apply_defaults(path string, explicit bool, prof string) error {
+	words, err := split_words(os.Getenv(ENV_OPTIONS))
+	if err != nil {
+		return fmt.Errorf("%s: %v", ENV_OPTIONS, err)
+	}
+	env, err := parse_settings(words)
+	if err != nil {
+		return fmt.Errorf("%s: %v", ENV_OPTIONS, err)
+	}
+	var cfg []profile_setting
+	if path != "" {
+		cfg, err = read_config(path, explicit)
+		if err != nil {
+			return err
+		}
+	}
+	// the profile may be defined in the configuration file
+	if prof != "" {
+		err = apply_profile(prof)
+		if err != nil {
+			return err
+		}
+	}
+	err = apply_settings(env)
+	if err != nil {
+		return fmt.Errorf("%s: %v", ENV_OPTIONS, err)
+	}
+	err = apply_settings(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// split a string into words separated by white space, using the quoting
+// rules of the shell: single quotes preserve all characters, double quotes
+// preserve all characters except a backslash escaping a double quote or a
+// backslash, and outside of quotes a backslash preserves the next character
+func split_words(s string) (words []string, err error) {
+	var word strings.Builder
+	in_word := false
+	var quote rune
+	escaped := false
+	var c rune
+	for _, c = range s {
+		switch {
+		case escaped:
+			if quote == '"' && c != '"' && c != '\\' {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+			in_word = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			in_word = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if in_word {
+				words = append(words, word.String())
+				word.Reset()
+				in_word = false
+			}
+		default:
+			word.WriteRune(c)
+			in_word = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if in_word {
+		words = append(words, word.String())
+	}
+	return
+}
+
+// parse a size in bytes with an optional binary unit suffix
+func parse_size(s string) (int, error) {
+	mult := 1
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K', 'k':
+			mult = 1024
+		case 'M', 'm':
+			mult = 1024 * 1024
+		case 'G', 'g':
+			mult = 1024 * 1024 * 1024
+		}
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size: %d", n)
+	}
+	if n > math.MaxInt/mult {
+		return 0, fmt.Errorf("size too large: %s", s)
+	}
+	return n * mult, nil
+}
+
+// units to measure indentation width
+const (
+	INDENT_WIDTH_BYTES = iota
+	INDENT_WIDTH_RUNES
+	INDENT_WIDTH_COLUMNS
+)
+
+// names of the units to measure indentation width
+var indent_width_units = map[string]int{
+	"bytes":   INDENT_WIDTH_BYTES,
+	"runes":   INDENT_WIDTH_RUNES,
+	"columns": INDENT_WIDTH_COLUMNS,
+}
+
+// ranges of East Asian wide and fullwidth characters, which occupy two
+// columns when displayed
+var wide_chars = [][2]rune{
+	{0x1100, 0x115f}, {0x231a, 0x231b}, {0x2329, 0x232a},
+	{0x23e9, 0x23ec}, {0x23f0, 0x23f0}, {0x23f3, 0x23f3},
+	{0x25fd, 0x25fe}, {0x2614, 0x2615}, {0x2648, 0x2653},
+	{0x267f, 0x267f}, {0x2693, 0x2693}, {0x26a1, 0x26a1},
+	{0x26aa, 0x26ab}, {0x26bd, 0x26be}, {0x26c4, 0x26c5},
+	{0x26ce, 0x26ce}, {0x26d4, 0x26d4}, {0x26ea, 0x26ea},
+	{0x26f2, 0x26f3}, {0x26f5, 0x26f5}, {0x26fa, 0x26fa},
+	{0x26fd, 0x26fd}, {0x2705, 0x2705}, {0x270a, 0x270b},
+	{0x2728, 0x2728}, {0x274c, 0x274c}, {0x274e, 0x274e},
+	{0x2753, 0x2755}, {0x2757, 0x2757}, {0x2795, 0x2797},
+	{0x27b0, 0x27b0}, {0x27bf, 0x27bf}, {0x2b1b, 0x2b1c},
+	{0x2b50, 0x2b50}, {0x2b55, 0x2b55}, {0x2e80, 0x303e},
+	{0x3041, 0x33ff}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff},
+	{0xa000, 0xa4cf}, {0xa960, 0xa97f}, {0xac00, 0xd7a3},
+	{0xf900, 0xfaff}, {0xfe10, 0xfe19}, {0xfe30, 0xfe6f},
+	{0xff00, 0xff60}, {0xffe0, 0xffe6}, {0x16fe0, 0x16fe4},
+	{0x17000, 0x18cff}, {0x1b000, 0x1b2ff}, {0x1f004, 0x1f004},
+	{0x1f0cf, 0x1f0cf}, {0x1f18e, 0x1f18e}, {0x1f191, 0x1f19a},
+	{0x1f200, 0x1f251}, {0x1f300, 0x1f64f}, {0x1f680, 0x1f6ff},
+	{0x1f900, 0x1f9ff}, {0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
+
+// number of columns occupied by a character when displayed
+// (combining marks and formatting characters have zero width)
+func rune_width(c rune) int {
+	if c < 0x300 {
+		return 1
+	}
+	if unicode.In(c, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	i := sort.Search(len(wide_chars), func(i int) bool {
+		return wide_chars[i][1] >= c
+	})
+	if i < len(wide_chars) && wide_chars[i][0] <= c {
+		return 2
+	}
+	return 1
+}
+
+// compute indentation depth from indentation byte sequence, counting
+// bytes, runes, or display columns
+func indentation_depth(in *[]byte, ts int, tab_is_n_spaces bool, width int) int {
+	if in == nil {
+		return 0
+	}
+	if ts < 2 && width == INDENT_WIDTH_BYTES {
+		return len(*in)
+	}
+	d := 0
+	var c rune
+	var i, n int
+	for i = 0; i < len(*in); i += n {
+		c, n = rune((*in)[i]), 1
+		if width != INDENT_WIDTH_BYTES && c >= utf8.RuneSelf {
+			c, n = utf8.DecodeRune((*in)[i:])
+		}
+		if c == '\t' && ts >= 2 {
+			if tab_is_n_spaces {
+				d += ts
+			} else {
+				d += ts - (d % ts)
+			}
+		} else if width == INDENT_WIDTH_COLUMNS {
+			d += rune_width(c)
+		} else {
+			d++
+		}
+	}
+	return d
+}
+
+// an IPv4 or IPv6 address, or a prefix in CIDR notation, inside a line
+var ip_re = regexp.MustCompile(
+	`([0-9A-Fa-f]*:[0-9A-Fa-f:]*:[0-9A-Fa-f.]*|[0-9]{1,3}(?:\.[0-9]{1,3}){3})(?:/([0-9]{1,3}))?`)
+
+// an IPv4 address used as a netmask or wildcard mask following an address
+var ip_mask_re = regexp.MustCompile(`^[ \t]+([0-9]{1,3}(?:\.[0-9]{1,3}){3})\b`)
+
+// an address found in a line, with its prefix length (-1 if none)
+type ip_item struct {
+	addr netip.Addr
+	bits int
+}
+
+// the prefix length given by an IPv4 netmask or wildcard mask following
+// an IPv4 address, or -1
+// (if the mask is valid as both, the netmask is used unless the address
+// has bits set outside the resulting prefix, e.g., "10.0.0.1 0.0.0.0")
+func mask_bits(addr, m netip.Addr) int {
+	b := m.As4()
+	v := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	nm, wc := -1, -1
+	// a netmask has contiguous one bits from the left
+	if v|(v-1) == 0xffffffff || v == 0 {
+		nm = bits.OnesCount32(v)
+	}
+	// a wildcard mask has contiguous one bits from the right
+	if v&(v+1) == 0 {
+		wc = 32 - bits.OnesCount32(v)
+	}
+	if nm > -1 && (wc == -1 || netip.PrefixFrom(addr, nm).Masked().Addr() == addr) {
+		return nm
+	}
+	return wc
+}
+
+// is the byte part of a word that would continue an address?
+func is_addr_byte(c byte) bool {
+	return c == '.' || c == ':' || c == '_' || (c >= '0' && c <= '9') ||
+		(c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+// extract IPv4 and IPv6 addresses and prefixes from a line, including
+// IPv4 addresses followed by a netmask or wildcard mask
+func extract_ips(l []byte) []ip_item {
+	var items []ip_item
+	var m []int
+	var addr netip.Addr
+	var err error
+	var n int
+	mask_end := 0 // a mask is not an address
+	for _, m = range ip_re.FindAllSubmatchIndex(l, -1) {
+		if m[0] < mask_end {
+			continue
+		}
+		// a trailing dot may end a sentence, but not continue a number
+		if (m[0] > 0 && is_addr_byte(l[m[0]-1])) ||
+			(m[1] < len(l) && is_addr_byte(l[m[1]]) && l[m[1]] != '.') ||
+			(m[1]+1 < len(l) && l[m[1]] == '.' && l[m[1]+1] >= '0' && l[m[1]+1] <= '9') {
+			continue
+		}
+		addr, err = netip.ParseAddr(string(l[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		item := ip_item{addr: addr.Unmap(), bits: -1}
+		if m[4] > -1 {
+			n, err = strconv.Atoi(string(l[m[4]:m[5]]))
+			if err != nil || n > addr.BitLen() {
+				continue
+			}
+			item.bits = n
+		} else if addr.Is4() {
+			mask := ip_mask_re.FindSubmatchIndex(l[m[1]:])
+			if mask != nil {
+				mask_addr, err := netip.ParseAddr(
+					string(l[m[1]+mask[2] : m[1]+mask[3]]))
+				if err == nil && mask_bits(addr, mask_addr) > -1 {
+					item.bits = mask_bits(addr, mask_addr)
+					mask_end = m[1] + mask[1]
+				}
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// match lines containing an IP address inside a prefix, or equal to an
+// address or prefix
+type ip_matcher struct {
+	prefix netip.Prefix // prefix containing, or address (and length) equal
+	equals bool         // check for equality instead of containment?
+	bits   int          // prefix length to check for equality, or -1
+}
+
+// create an IP matcher checking for addresses and prefixes inside a prefix
+// (a single address is used as a host prefix)
+func new_ip_in(s string) (*ip_matcher, error) {
+	pfx, err := netip.ParsePrefix(s)
+	if err != nil && strings.Contains(s, "/") {
+		return nil, err
+	} else if err != nil {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		pfx = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	pfx = netip.PrefixFrom(pfx.Addr().Unmap(), pfx.Bits()).Masked()
+	return &ip_matcher{prefix: pfx, bits: -1}, nil
+}
+
+// create an IP matcher checking for an address, or an address with a
+// prefix length
+func new_ip_equals(s string) (*ip_matcher, error) {
+	pfx, err := netip.ParsePrefix(s)
+	if err != nil && strings.Contains(s, "/") {
+		return nil, err
+	} else if err == nil {
+		return &ip_matcher{
+			prefix: netip.PrefixFrom(pfx.Addr().Unmap(), pfx.Bits()),
+			equals: true,
+			bits:   pfx.Bits(),
+		}, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return nil, err
+	}
+	addr = addr.Unmap()
+	return &ip_matcher{
+		prefix: netip.PrefixFrom(addr, addr.BitLen()),
+		equals: true,
+		bits:   -1,
+	}, nil
+}
+
+// check if the line contains a matching address or prefix
+func (im *ip_matcher) Match(l []byte) bool {
+	var item ip_item
+	for _, item = range extract_ips(l) {
+		if im.equals {
+			if item.addr == im.prefix.Addr() &&
+				(im.bits == -1 || im.bits == item.bits) {
+				return true
+			}
+		} else if im.prefix.Contains(item.addr) &&
+			(item.bits == -1 || item.bits >= im.prefix.Bits()) {
+			return true
+		}
+	}
+	return false
+}
+
+// network interface types, abbreviations are resolved to the first type
+// starting with the abbreviation (in this order)
+var iface_types = []string{
+	"Ethernet", "FastEthernet", "GigabitEthernet", "TenGigabitEthernet",
+	"TwoGigabitEthernet", "TwentyFiveGigE", "FortyGigabitEthernet",
+	"FiftyGigE", "HundredGigE", "FourHundredGigE", "Port-channel",
+	"Loopback", "Vlan", "Vxlan", "Management", "Tunnel", "Serial",
+	"Bundle-Ether", "BDI", "mgmt",
+}
+
+// interface type abbreviations that are not prefixes of the type name
+var iface_aliases = map[string]string{
+	"be": "bundle-ether",
+}
+
+// interface configuration line, optionally comprising a range
+var iface_line_re = regexp.MustCompile(`^\s*(?i:interface)\s+(?:(?i:range)\s+)?(.*)$`)
+
+// interface name, optionally with a range of the last number
+var iface_re = regexp.MustCompile(
+	`^\s*([A-Za-z](?:[A-Za-z-]*[A-Za-z])?)?\s*((?:[0-9]+[/:.])*)([0-9]+)(?:\s*-\s*([0-9]+))?(?:\s.*)?$`)
+
+// single interface name with type
+var iface_name_re = regexp.MustCompile(
+	`^[A-Za-z](?:[A-Za-z-]*[A-Za-z])?\s*(?:[0-9]+[/:.])*[0-9]+$`)
+
+// normalize an interface type, resolving abbreviations
+func normalize_iface_type(t string) string {
+	t = strings.ToLower(t)
+	if n, ok := iface_aliases[t]; ok {
+		return n
+	}
+	var n string
+	for _, n = range iface_types {
+		n = strings.ToLower(n)
+		if strings.HasPrefix(n, t) {
+			return n
+		}
+	}
+	return t
+}
+
+// interface, or range of interfaces differing in the last number
+type iface struct {
+	typ    string // normalized type
+	prefix string // numbers before the last one, including separators
+	lo, hi int    // range of the last number
+}
+
+// parse an interface name or range, using the type of the previous
+// interface if the type is omitted
+func parse_iface(s, prev_typ string) (iface, bool) {
+	var res iface
+	m := iface_re.FindStringSubmatch(s)
+	if m == nil {
+		return res, false
+	}
+	res.typ = prev_typ
+	if m[1] != "" {
+		res.typ = normalize_iface_type(m[1])
+	}
+	res.prefix = m[2]
+	res.lo, _ = strconv.Atoi(m[3])
+	res.hi = res.lo
+	if m[4] != "" {
+		res.hi, _ = strconv.Atoi(m[4])
+	}
+	return res, res.typ != ""
+}
+
+// matcher for interface configuration lines covering an interface
+type iface_matcher struct {
+	name iface
+}
+
+// create an interface matcher for an interface name
+func new_iface_matcher(s string) (*iface_matcher, error) {
+	if !iface_name_re.MatchString(s) {
+		return nil, errors.New("invalid interface name " + strconv.Quote(s))
+	}
+	name, _ := parse_iface(s, "")
+	return &iface_matcher{name: name}, nil
+}
+
+// check if the line configures the interface, possibly as part of a range
+func (im *iface_matcher) Match(l []byte) bool {
+	m := iface_line_re.FindSubmatch(l)
+	if m == nil {
+		return false
+	}
+	var typ string
+	var item string
+	for _, item = range strings.Split(string(m[1]), ",") {
+		i, ok := parse_iface(item, typ)
+		if !ok {
+			return false
+		}
+		typ = i.typ
+		if i.typ == im.name.typ && i.prefix == im.name.prefix &&
+			i.lo <= im.name.lo && im.name.lo <= i.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// compile a list of patterns, adjusted according to command line flags
+func compile_patterns(pats []string, fixed, ign_case bool) ([]line_matcher, error) {
+	var res []line_matcher
+	var re *regexp.Regexp
+	var err error
+	var pat string
+	for _, pat = range pats {
+		if fixed {
+			pat = regexp.QuoteMeta(pat)
+		}
+		if ign_case {
+			pat = RE_IGN_CASE + pat
+		}
+		re, err = regexp.Compile(pat)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// create the line memory for the selected section algorithm variant and
+// connect it to the given line output
+func (p *section_params) new_memory(begin, omit bool, act line_output) (memory line_memory) {
+	// line memory selection (also affected by --headers and filters)
+	if p.top_level || (p.headers && begin) {
+		memory = new(top_level_lm)
+	} else if p.enclosing {
+		memory = new(enclosing_lm)
+	} else if p.parent > 0 {
+		memory = &parent_lm{levels: p.parent}
+	} else if p.siblings {
+		memory = &siblings_lm{preceding: true, following: -1}
+	} else if p.following_siblings > 0 {
+		memory = &siblings_lm{following: p.following_siblings}
+	} else if p.headers && !p.flatten {
+		// set commands depend on lines that are not selected
+		memory = &headers_lm{
+			unselected: omit || p.output_format == OUTPUT_SET,
+		}
+	} else if p.headers || p.filter != nil || p.flatten {
+		memory = new(simple_line_memory)
+	} else {
+		memory = new(memoryless_lm)
+	}
+	// also select section headers?
+	if p.headers {
+		memory.add_headers()
+	}
+	// filter sections by their contents?
+	if p.filter != nil {
+		memory.set_filter(p.filter)
+	}
+	memory.set_limit(p.max_buffer)
+	// prefix lines with their ancestors?
+	if p.flatten {
+		memory.set_flatten(p.flatten_sep)
+	}
+	// "ignore" line printer may be different from normal one
+	ign := act
+	if p.omit_ignored {
+		ign = &line_printer{quiet: true}
+	}
+	// lines are converted to set commands when printing, this needs to
+	// consider all lines, including ignored lines
+	if p.output_format == OUTPUT_SET {
+		sc := new(set_converter)
+		act = &set_output{out: act, conv: sc}
+		ign = &set_output{out: ign, conv: sc}
+	}
+	memory.set_act(act)
+	memory.set_ign(ign)
+	return
+}
+
+// check if a line is ignored for section boundary determination
+func (p *section_params) ignored_line(l []byte) bool {
+	return (p.ignore_re != nil && p.ignore_re.Match(l)) ||
+		(p.comment_re != nil && p.comment_re.Match(l))
+}
+
+// determine the indentation depth of a line, skipping an ignored prefix
+func (p *section_params) line_depth(l []byte) int {
+	ind_off := 0
+	if p.ignore_prefix_re != nil {
+		start_end := p.ignore_prefix_re.FindIndex(l)
+		// prefix must start at beginning of line
+		if start_end != nil && start_end[0] == 0 {
+			ind_off = start_end[1]
+		}
+	}
+	li := p.ind_re.Find(l[ind_off:])
+	return indentation_depth(&li, p.tab_size, p.tab_is_n_spaces,
+		p.indent_width)
+}
+
+// check if a line matches the pattern, taking --invert-match into account
+// (without a pattern, e.g., when using a key path, no line matches)
+func (p *section_params) match(l []byte) bool {
+	var m bool
+	if p.line_match != nil {
+		m = p.line_match.Match(l)
+	} else if p.pat_re != nil {
+		m = p.pat_re.Match(l)
+	} else {
+		return false
+	}
+	if p.invert_match {
+		m = !m
+	}
+	return m
+}
+
+// expand the --label-section template with the submatches of the pattern
+// in a line
+func (p *section_params) section_label(l []byte) []byte {
+	text := trim_eol(l)
+	m := p.pat_re.FindSubmatchIndex(text)
+	if m == nil {
+		return []byte{}
+	}
+	return p.pat_re.Expand(nil, p.label_tmpl, text, m)
+}
+
+// input formats
+const (
+	INPUT_TEXT = iota
+	INPUT_SET
+)
+
+// names of the input formats
+var input_formats = map[string]int{
+	"text": INPUT_TEXT,
+	"set":  INPUT_SET,
+}
+
+// output formats
+const (
+	OUTPUT_TEXT = iota
+	OUTPUT_SET
+	OUTPUT_HTML
+	OUTPUT_MARKDOWN
+)
+
+// names of the output formats
+var output_formats = map[string]int{
+	"text":     OUTPUT_TEXT,
+	"set":      OUTPUT_SET,
+	"html":     OUTPUT_HTML,
+	"markdown": OUTPUT_MARKDOWN,
+}
+
+// orders for sorting sections
+const (
+	SORT_NONE = iota
+	SORT_LEXICAL
+	SORT_NATURAL
+	SORT_NUMERIC
+)
+
+// names of the orders for sorting sections
+var sort_orders = map[string]int{
+	"lexical": SORT_LEXICAL,
+	"natural": SORT_NATURAL,
+	"numeric": SORT_NUMERIC,
+}
+
+// handling of lines longer than the maximum line length
+const (
+	LONG_LINES_ERROR = iota
+	LONG_LINES_SKIP
+	LONG_LINES_TRUNCATE
+)
+
+// names of the long line handling policies
+var long_lines_policies = map[string]int{
+	"error":    LONG_LINES_ERROR,
+	"skip":     LONG_LINES_SKIP,
+	"truncate": LONG_LINES_TRUNCATE,
+}
+
+// names of the output line terminator variants, using the line terminator
+// of the input line is represented by the empty string
+var line_endings_names = map[string]string{
+	"lf":       "\n",
+	"crlf":     "\r\n",
+	"preserve": "",
+}
+
+// reader splitting input into lines of arbitrary length, like
+// bufio.ScanLines, i.e., without the line terminator "\n" or "\r\n"
+// (a "\r" at the end of the input is considered a line terminator, too)
+// the line including its terminator is available as well
+// if a maximum line length is given, at most this many bytes of a line
+// are kept in memory, the rest of the line is read and discarded
+// idle input (err_idle) between two lines can be reported, otherwise
+// reading continues
+type line_reader struct {
+	r           *bufio.Reader
+	max         int    // maximum line length, 0 for no limit
+	line        []byte // the current line, possibly truncated
+	raw         []byte // the current line including its terminator
+	long        bool   // is the current line longer than the maximum?
+	err         error  // error other than io.EOF and err_idle, if any
+	report_idle bool   // return from next() when the input is idle?
+	idle        bool   // has next() returned because the input is idle?
+}
+
+// create a new line reader with an optional maximum line length
+func new_line_reader(r io.Reader, max int) *line_reader {
+	return &line_reader{r: bufio.NewReader(r), max: max}
+}
+
+// read the next line, return false at the end of input, on error, or
+// when reporting idle input
+func (lr *line_reader) next() bool {
+	var frag []byte
+	var err error
+	var end [2]byte // the last two bytes of the complete line
+	n := 0          // length of the complete line including terminator
+	lr.line = lr.line[:0]
+	lr.long = false
+	lr.idle = false
+	for {
+		frag, err = lr.r.ReadSlice('\n')
+		// idle input inside a line is not reported
+		if err == err_idle {
+			if n == 0 && len(frag) == 0 && lr.report_idle {
+				lr.idle = true
+				return false
+			}
+			err = bufio.ErrBufferFull
+		}
+		n += len(frag)
+		if len(frag) > 1 {
+			end[0], end[1] = frag[len(frag)-2], frag[len(frag)-1]
+		} else if len(frag) == 1 {
+			end[0], end[1] = end[1], frag[0]
+		}
+		if lr.max > 0 && len(lr.line)+len(frag) > lr.max {
+			frag = frag[:lr.max-len(lr.line)]
+		}
+		lr.line = append(lr.line, frag...)
+		if err != bufio.ErrBufferFull {
+			break
+		}
+	}
+	if err != nil && err != io.EOF {
+		lr.err = err
+		return false
+	}
+	if n == 0 {
+		return false
+	}
+	eol := 0
+	if end[1] == '\n' && n > 1 && end[0] == '\r' {
+		eol = 2
+	} else if end[1] == '\n' || end[1] == '\r' {
+		eol = 1
+	}
+	if n == len(lr.line) {
+		lr.raw = lr.line
+		lr.line = lr.line[:n-eol]
+		return true
+	}
+	// the line has been cut off
+	if n-eol > lr.max {
+		lr.long = true
+	}
+	if len(lr.line) > n-eol {
+		lr.line = lr.line[:n-eol]
+	}
+	lr.raw = make([]byte, 0, len(lr.line)+eol)
+	lr.raw = append(lr.raw, lr.line...)
+	lr.raw = append(lr.raw, end[2-eol:]...)
+	return true
+}
+
+// the beginning of the input, i.e., the data read by the first read
+// (waiting for data if the input is idle)
+func (lr *line_reader) first_block() []byte {
+	for {
+		_, err := lr.r.Peek(1)
+		if err != err_idle {
+			break
+		}
+	}
+	b, _ := lr.r.Peek(lr.r.Buffered())
+	return b
+}
+
+// handling of binary input
+const (
+	BINARY_FILES_BINARY = iota
+	BINARY_FILES_TEXT
+	BINARY_FILES_WITHOUT_MATCH
+)
+
+// names of the binary input handling modes
+var binary_files_modes = map[string]int{
+	"binary":        BINARY_FILES_BINARY,
+	"text":          BINARY_FILES_TEXT,
+	"without-match": BINARY_FILES_WITHOUT_MATCH,
+}
+
+// does the locale use UTF-8 character encoding?
+func utf8_locale() bool {
+	var name, val string
+	for _, name = range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		val = os.Getenv(name)
+		if val != "" {
+			break
+		}
+	}
+	val = strings.ToLower(val)
+	return strings.Contains(val, "utf-8") || strings.Contains(val, "utf8")
+}
+
+// data containing a NUL byte is considered binary, as is data containing
+// invalid UTF-8 if check_utf8 is true, e.g., when using a UTF-8 locale
+// (a multi-byte character cut off at the end of the data is ignored)
+func is_binary(b []byte, check_utf8 bool) bool {
+	if bytes.IndexByte(b, 0) > -1 {
+		return true
+	}
+	if !check_utf8 {
+		return false
+	}
+	var n int
+	for n = 1; n <= utf8.UTFMax && n <= len(b); n++ {
+		if utf8.RuneStart(b[len(b)-n]) {
+			if !utf8.FullRune(b[len(b)-n:]) {
+				b = b[:len(b)-n]
+			}
+			break
+		}
+	}
+	return !utf8.Valid(b)
+}
+
+// a character encoding that input can be converted from, and output can
+// be converted to, using UTF-8 internally
+type text_encoding struct {
+	name string
+	bom  []byte // byte order mark
+	// convert input to UTF-8, appending to out, and return the number of
+	// bytes used (incomplete characters are kept unless at the end)
+	decode func(in, out []byte, eof bool) (int, []byte)
+	// convert one character to the encoding, appending to out
+	encode func(r rune, out []byte) []byte
+}
+
+// characters 0x80 to 0x9f of Windows-1252, the other characters are
+// identical to ISO-8859-1 (undefined characters are mapped as in the
+// WHATWG encoding standard)
+var cp1252_high = [32]rune{
+	0x20ac, 0x0081, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008d, 0x017d, 0x008f,
+	0x0090, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x009d, 0x017e, 0x0178,
+}
+
+// supported character encodings (UTF-8 is used unchanged)
+var (
+	enc_utf8 = &text_encoding{
+		name: "utf-8",
+		bom:  []byte{0xef, 0xbb, 0xbf},
+	}
+	enc_utf16le = &text_encoding{
+		name:   "utf-16le",
+		bom:    []byte{0xff, 0xfe},
+		decode: decode_utf16(binary.LittleEndian),
+		encode: encode_utf16(binary.LittleEndian),
+	}
+	enc_utf16be = &text_encoding{
+		name:   "utf-16be",
+		bom:    []byte{0xfe, 0xff},
+		decode: decode_utf16(binary.BigEndian),
+		encode: encode_utf16(binary.BigEndian),
+	}
+	enc_latin1 = &text_encoding{
+		name:   "iso-8859-1",
+		decode: decode_latin1,
+		encode: encode_latin1,
+	}
+	enc_cp1252 = &text_encoding{
+		name:   "windows-1252",
+		decode: decode_cp1252,
+		encode: encode_cp1252,
+	}
+)
+
+// encodings recognized by their byte order mark
+var bom_encodings = []*text_encoding{enc_utf8, enc_utf16le, enc_utf16be}
+
+// names of the character encodings, "utf-16" selects the byte order
+// from the byte order mark and defaults to big endian,
+// "auto" (represented as nil) uses the byte order mark only
+var encoding_names = map[string]*text_encoding{
+	"auto":         nil,
+	"utf-8":        enc_utf8,
+	"utf8":         enc_utf8,
+	"utf-16":       enc_utf16be,
+	"utf16":        enc_utf16be,
+	"utf-16le":     enc_utf16le,
+	"utf-16be":     enc_utf16be,
+	"iso-8859-1":   enc_latin1,
+	"latin1":       enc_latin1,
+	"windows-1252": enc_cp1252,
+	"cp1252":       enc_cp1252,
+}
+
+// create a UTF-16 decoding function for the given byte order
+func decode_utf16(order binary.ByteOrder) func(in, out []byte, eof bool) (int, []byte) {
+	return func(in, out []byte, eof bool) (int, []byte) {
+		var c, c2 rune
+		i := 0
+		for i+1 < len(in) {
+			c = rune(order.Uint16(in[i:]))
+			if !utf16.IsSurrogate(c) {
+				out = append(out, string(c)...)
+				i += 2
+				continue
+			}
+			if i+3 >= len(in) {
+				if !eof {
+					break
+				}
+				out = append(out, string(utf8.RuneError)...)
+				i += 2
+				continue
+			}
+			c2 = rune(order.Uint16(in[i+2:]))
+			c = utf16.DecodeRune(c, c2)
+			out = append(out, string(c)...)
+			if c == utf8.RuneError {
+				i += 2
+			} else {
+				i += 4
+			}
+		}
+		if eof && i < len(in) {
+			out = append(out, string(utf8.RuneError)...)
+			i = len(in)
+		}
+		return i, out
+	}
+}
+
+// create a UTF-16 encoding function for the given byte order
+func encode_utf16(order binary.ByteOrder) func(r rune, out []byte) []byte {
+	return func(r rune, out []byte) []byte {
+		var b [2]byte
+		var u uint16
+		for _, u = range utf16.Encode([]rune{r}) {
+			order.PutUint16(b[:], u)
+			out = append(out, b[:]...)
+		}
+		return out
+	}
+}
+
+// convert ISO-8859-1 to UTF-8
+func decode_latin1(in, out []byte, eof bool) (int, []byte) {
+	var b byte
+	for _, b = range in {
+		out = append(out, string(rune(b))...)
+	}
+	return len(in), out
+}
+
+// convert a character to ISO-8859-1, using "?" if impossible
+func encode_latin1(r rune, out []byte) []byte {
+	if r > 0xff {
+		return append(out, '?')
+	}
+	return append(out, byte(r))
+}
+
+// convert Windows-1252 to UTF-8
+func decode_cp1252(in, out []byte, eof bool) (int, []byte) {
+	var b byte
+	for _, b = range in {
+		if b >= 0x80 && b <= 0x9f {
+			out = append(out, string(cp1252_high[b-0x80])...)
+		} else {
+			out = append(out, string(rune(b))...)
+		}
+	}
+	return len(in), out
+}
+
+// convert a character to Windows-1252, using "?" if impossible
+func encode_cp1252(r rune, out []byte) []byte {
+	if r < 0x80 || (r >= 0xa0 && r <= 0xff) {
+		return append(out, byte(r))
+	}
 	var i int
-	for i = range *lm.lines {
-		sl = &(*lm.lines)[i]
-		// section has indentation level of first non-ignored line
-		if sl.l_ind == -1 {
-			err = lm.ign.print_line(&sl.data, sl.nr, false, true)
-			if err != nil {
-				if min_ind == -1 {
-					return s_ind, err
+	for i = range cp1252_high {
+		if cp1252_high[i] == r {
+			return append(out, byte(0x80+i))
+		}
+	}
+	return append(out, '?')
+}
+
+// reader converting input to UTF-8
+type decoding_reader struct {
+	r   io.Reader
+	enc *text_encoding
+	buf []byte // space for reading input
+	in  []byte // input not yet converted
+	out []byte // converted input not yet returned
+	err error  // error from reading input
+}
+
+// return converted input
+func (d *decoding_reader) Read(b []byte) (int, error) {
+	var n, used int
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, d.err = d.r.Read(d.buf)
+		d.in = append(d.in, d.buf[:n]...)
+		// idle input is not the end of the input
+		if d.err == err_idle {
+			d.err = nil
+			return 0, err_idle
+		}
+		used, d.out = d.enc.decode(d.in, d.out[:0], d.err != nil)
+		d.in = append(d.in[:0], d.in[used:]...)
+	}
+	n = copy(b, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// determine the character encoding of the input from a byte order mark,
+// unless an encoding is given, and return a reader converting the input
+// to UTF-8, the encoding (nil if unknown), and if the input starts with
+// a byte order mark (which is removed)
+func decode_input(r io.Reader, enc *text_encoding) (io.Reader, *text_encoding, bool) {
+	br := bufio.NewReader(r)
+	for {
+		_, err := br.Peek(1)
+		if err != err_idle {
+			break
+		}
+	}
+	b, _ := br.Peek(br.Buffered())
+	var bom_enc, e *text_encoding
+	for _, e = range bom_encodings {
+		if bytes.HasPrefix(b, e.bom) {
+			bom_enc = e
+			break
+		}
+	}
+	// "utf-16" uses the byte order mark to select the byte order
+	if enc == enc_utf16be && bom_enc == enc_utf16le {
+		enc = enc_utf16le
+	}
+	if enc == nil {
+		enc = bom_enc
+	}
+	bom := enc != nil && enc == bom_enc
+	if bom {
+		br.Discard(len(enc.bom))
+	}
+	if enc == nil || enc.decode == nil {
+		return br, enc, bom
+	}
+	d := &decoding_reader{
+		r:   br,
+		enc: enc,
+		buf: make([]byte, DECODE_BUF_SIZE),
+	}
+	return d, enc, bom
+}
+
+// writer converting UTF-8 to a different character encoding
+type encoding_writer struct {
+	w   io.Writer
+	enc *text_encoding
+	bom bool // write a byte order mark before the first output?
+	buf []byte
+}
+
+// convert UTF-8 to the output encoding and write it
+func (e *encoding_writer) Write(b []byte) (int, error) {
+	var err error
+	if e.bom {
+		e.bom = false
+		_, err = e.w.Write(e.enc.bom)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if e.enc.encode == nil {
+		return e.w.Write(b)
+	}
+	e.buf = e.buf[:0]
+	var c rune
+	var i, n int
+	for i < len(b) {
+		c, n = utf8.DecodeRune(b[i:])
+		e.buf = e.enc.encode(c, e.buf)
+		i += n
+	}
+	_, err = e.w.Write(e.buf)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// a YAML node starting on a line, which may contain other nodes
+type yaml_node struct {
+	ind   int       // column of the node
+	depth int       // structural depth of the node
+	open  bool      // is this a mapping key without a value on its line?
+	elem  path_elem // mapping key or sequence index of the node
+	count int       // number of sequence entries inside the node
+}
+
+// state of a YAML flow collection or quoted scalar spanning lines
+type yaml_flow struct {
+	level    int  // nesting level of flow collections
+	quote    byte // quote character of an unterminated quoted scalar
+	escape   bool // is the next character escaped?
+	at_start bool // may a quoted scalar or flow collection start here?
+}
+
+// is this flow state inside a flow collection or quoted scalar?
+func (f *yaml_flow) active() bool {
+	return f.level > 0 || f.quote != 0
+}
+
+// update the flow state according to a part of a line
+func (f *yaml_flow) scan(b []byte) {
+	var i int
+	var c byte
+	for i = 0; i < len(b); i++ {
+		c = b[i]
+		if f.quote == '"' {
+			if f.escape {
+				f.escape = false
+			} else if c == '\\' {
+				f.escape = true
+			} else if c == '"' {
+				f.quote = 0
+			}
+			continue
+		}
+		if f.quote == '\'' {
+			if c == '\'' && i+1 < len(b) && b[i+1] == '\'' {
+				i++
+			} else if c == '\'' {
+				f.quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == ' ' || c == '\t':
+			continue
+		case c == '#' && (i == 0 || b[i-1] == ' ' || b[i-1] == '\t'):
+			return
+		case (c == '"' || c == '\'') && f.at_start:
+			f.quote = c
+		case (c == '[' || c == '{') && (f.at_start || f.level > 0):
+			f.level++
+			f.at_start = true
+			continue
+		case (c == ']' || c == '}') && f.level > 0:
+			f.level--
+		case (c == ',' || c == ':') && f.level > 0:
+			f.at_start = true
+			continue
+		}
+		f.at_start = false
+	}
+}
+
+// a YAML block scalar indicator, possibly following a tag or an anchor
+var yaml_block_re = regexp.MustCompile(
+	`^(?:[!&][^ \t]*[ \t]+)*[|>][-+0-9]*[ \t]*(?:#.*)?$`)
+
+// determine the structural depth of YAML lines, taking block sequences,
+// block scalars, flow collections, quoted scalars, comments, and
+// documents into account
+type yaml_parser struct {
+	stack       []yaml_node // nodes that may contain following lines
+	block       bool        // inside a block scalar?
+	block_ind   int         // block scalar lines are indented more
+	block_depth int         // depth of block scalar lines
+	flow        yaml_flow   // state of multi-line flow content
+	flow_depth  int         // depth of flow continuation lines
+	count       int         // number of top level sequence entries
+	nodes       []path_node // nodes starting on the last line
+}
+
+// count the space characters used as YAML indentation
+func yaml_indentation(l []byte) int {
+	i := 0
+	for i < len(l) && l[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+// is this line (without indentation) a YAML document marker?
+func yaml_doc_marker(l []byte) bool {
+	return (bytes.HasPrefix(l, []byte("---")) ||
+		bytes.HasPrefix(l, []byte("..."))) &&
+		(len(l) == 3 || l[3] == ' ' || l[3] == '\t')
+}
+
+// return the index following the ":" of a YAML mapping key, or -1
+func yaml_key_end(b []byte) int {
+	i := 0
+	// skip node properties (tags and anchors)
+	for i < len(b) && (b[i] == '!' || b[i] == '&') {
+		for i < len(b) && b[i] != ' ' && b[i] != '\t' {
+			i++
+		}
+		for i < len(b) && (b[i] == ' ' || b[i] == '\t') {
+			i++
+		}
+	}
+	if i == len(b) || bytes.IndexByte([]byte("[{#|>*%@`"), b[i]) > -1 {
+		return -1
+	}
+	if b[i] == '"' || b[i] == '\'' {
+		f := yaml_flow{at_start: true}
+		f.scan(b[i : i+1])
+		for i++; i < len(b) && f.quote != 0; i++ {
+			f.scan(b[i : i+1])
+		}
+		for i < len(b) && (b[i] == ' ' || b[i] == '\t') {
+			i++
+		}
+		if i < len(b) && b[i] == ':' &&
+			(i+1 == len(b) || b[i+1] == ' ' || b[i+1] == '\t') {
+			return i + 1
+		}
+		return -1
+	}
+	for ; i < len(b); i++ {
+		if b[i] == ':' && (i+1 == len(b) || b[i+1] == ' ' || b[i+1] == '\t') {
+			return i + 1
+		}
+		if b[i] == '#' && i > 0 && (b[i-1] == ' ' || b[i-1] == '\t') {
+			return -1
+		}
+	}
+	return -1
+}
+
+// the name of a YAML mapping key, given the key without the ":"
+func yaml_key_name(b []byte) string {
+	b = bytes.TrimSpace(b)
+	for len(b) > 0 && (b[0] == '!' || b[0] == '&') {
+		i := bytes.IndexAny(b, " \t")
+		if i == -1 {
+			return ""
+		}
+		b = bytes.TrimLeft(b[i:], " \t")
+	}
+	key := string(b)
+	if len(key) > 1 && key[0] == '"' {
+		if uq, err := strconv.Unquote(key); err == nil {
+			return uq
+		}
+	} else if len(key) > 1 && key[0] == '\'' {
+		return strings.Replace(key[1:len(key)-1], "''", "'", -1)
+	}
+	return key
+}
+
+// add a node starting on the current line
+func (y *yaml_parser) push(n yaml_node) {
+	// sequence entries are numbered inside their parent node
+	if n.elem.index >= 0 {
+		if len(y.stack) > 0 {
+			n.elem.index = y.stack[len(y.stack)-1].count
+			y.stack[len(y.stack)-1].count++
+		} else {
+			n.elem.index = y.count
+			y.count++
+		}
+	}
+	y.stack = append(y.stack, n)
+	path := make([]path_elem, len(y.stack))
+	var i int
+	for i = range y.stack {
+		path[i] = y.stack[i].elem
+	}
+	y.nodes = append(y.nodes, path_node{depth: n.depth, path: path})
+}
+
+// the nodes starting on the last line
+func (y *yaml_parser) line_nodes() []path_node {
+	return y.nodes
+}
+
+// is this a YAML value without content, i.e., empty or just a comment?
+func yaml_empty_value(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t")
+	return len(b) == 0 || b[0] == '#'
+}
+
+// determine the structural depth of a line, and if the line is to be
+// ignored as a section boundary (blank and comment lines)
+func (y *yaml_parser) depth(l []byte) (int, bool) {
+	col := yaml_indentation(l)
+	blank := len(bytes.TrimLeft(l, " \t")) == 0
+	doc := col == 0 && yaml_doc_marker(l)
+	y.nodes = y.nodes[:0]
+	// block scalar content is more indented than its parent node
+	if y.block {
+		if blank {
+			return y.block_depth, true
+		}
+		if col > y.block_ind && !doc {
+			return y.block_depth, false
+		}
+		y.block = false
+	}
+	// continuation lines of multi-line flow content
+	if y.flow.active() && !doc {
+		if blank {
+			return y.flow_depth, true
+		}
+		y.flow.scan(l)
+		return y.flow_depth, false
+	}
+	y.flow = yaml_flow{}
+	if blank || l[col] == '#' {
+		return 0, true
+	}
+	line_depth := 0
+	owner := yaml_node{ind: col}
+	value := l[col:]
+	if doc {
+		// a document starts or ends at the top level
+		y.stack = nil
+		y.count = 0
+		value = value[3:]
+	} else {
+		// the line belongs to the innermost node indented less, or to a
+		// mapping key at the same indentation for a block sequence
+		seq := l[col] == '-' && (col+1 == len(l) || l[col+1] == ' ')
+		var top *yaml_node
+		for len(y.stack) > 0 {
+			top = &y.stack[len(y.stack)-1]
+			if top.ind < col || (top.ind == col && top.open && seq) {
+				break
+			}
+			y.stack = y.stack[:len(y.stack)-1]
+		}
+		d := 0
+		if len(y.stack) > 0 {
+			d = y.stack[len(y.stack)-1].depth + 1
+		}
+		line_depth = d
+		owner.depth = d
+		// block sequence entries and explicit keys and values
+		pos := col
+		for pos < len(l) && (l[pos] == '-' || l[pos] == '?' || l[pos] == ':') &&
+			(pos+1 == len(l) || l[pos+1] == ' ') {
+			owner = yaml_node{ind: pos, depth: d}
+			y.push(owner)
+			d++
+			for pos++; pos < len(l) && l[pos] == ' '; pos++ {
+			}
+		}
+		value = l[pos:]
+		// mapping key
+		if key_end := yaml_key_end(value); key_end > -1 {
+			owner = yaml_node{
+				ind:   pos,
+				depth: d,
+				open:  yaml_empty_value(value[key_end:]),
+				elem:  path_elem{key: yaml_key_name(value[:key_end-1]), index: -1},
+			}
+			value = value[key_end:]
+			y.push(owner)
+		}
+	}
+	value = bytes.TrimLeft(value, " \t")
+	if yaml_block_re.Match(value) {
+		y.block = true
+		y.block_ind = owner.ind
+		if doc {
+			y.block_ind = -1
+		}
+		y.block_depth = owner.depth + 1
+	} else {
+		y.flow.at_start = true
+		y.flow.scan(value)
+		y.flow_depth = owner.depth + 1
+	}
+	return line_depth, false
+}
+
+// does the input look like JSON, i.e., start with an object or array?
+func is_json(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
+
+// one element of the path of a node in a structured document, i.e.,
+// a mapping key or a sequence index
+type path_elem struct {
+	key   string
+	index int // -1 for a mapping key
+}
+
+// a node starting on a line, with its structural depth and path
+type path_node struct {
+	depth int
+	path  []path_elem
+}
+
+// determine the structure of a document line by line
+type structure_parser interface {
+	// return the structural depth of a line and if it is to be ignored
+	// as a section boundary
+	depth(l []byte) (int, bool)
+	// return the nodes starting on the last line
+	line_nodes() []path_node
+}
+
+// one element of a key path query
+type key_path_elem struct {
+	key      string
+	index    int
+	is_index bool // does this element select a sequence index?
+	any      bool // does this element select any key or index?
+}
+
+// a key path query, e.g., "spec.containers[*].env"
+type key_path []key_path_elem
+
+// parse a key path consisting of keys separated by dots, each key
+// optionally followed by indices in brackets, where "*" selects any key
+// or index
+func parse_key_path(s string) (key_path, error) {
+	var kp key_path
+	var seg, idx string
+	var i, n int
+	var err error
+	for _, seg = range strings.Split(s, ".") {
+		i = strings.IndexByte(seg, '[')
+		if i == -1 {
+			i = len(seg)
+		}
+		if i == 0 && (len(kp) > 0 || seg == "") {
+			return nil, fmt.Errorf("empty key in key path %q", s)
+		}
+		if i > 0 {
+			kp = append(kp, key_path_elem{
+				key: seg[:i],
+				any: seg[:i] == "*",
+			})
+		}
+		for seg = seg[i:]; seg != ""; seg = seg[i+1:] {
+			i = strings.IndexByte(seg, ']')
+			if seg[0] != '[' || i == -1 {
+				return nil, fmt.Errorf("invalid index in key path %q", s)
+			}
+			idx = seg[1:i]
+			if idx == "*" {
+				kp = append(kp, key_path_elem{is_index: true, any: true})
+				continue
+			}
+			n, err = strconv.Atoi(idx)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid index in key path %q", s)
+			}
+			kp = append(kp, key_path_elem{index: n, is_index: true})
+		}
+	}
+	return kp, nil
+}
+
+// does the key path select a node with the given path?
+func (kp key_path) match(path []path_elem) bool {
+	if len(kp) != len(path) {
+		return false
+	}
+	var i int
+	for i = range kp {
+		if kp[i].is_index != (path[i].index >= 0) {
+			return false
+		}
+		if kp[i].any {
+			continue
+		}
+		if kp[i].is_index && kp[i].index != path[i].index {
+			return false
+		}
+		if !kp[i].is_index && kp[i].key != path[i].key {
+			return false
+		}
+	}
+	return true
+}
+
+// a JSON object or array containing the current line
+type json_container struct {
+	array bool
+	elem  path_elem // path element of the current member or element
+	count int       // number of elements started in an array
+}
+
+// determine the structural depth of JSON lines from brackets and braces
+type json_parser struct {
+	stack []json_container
+	str   bool // inside a string?
+	esc   bool // is the next character escaped?
+	key   bool // may a key start here?
+	val   bool // may a value start here?
+	buf   []byte
+	nodes []path_node
+}
+
+// the path of a value starting inside the innermost container
+func (j *json_parser) path() []path_elem {
+	path := make([]path_elem, len(j.stack))
+	var i int
+	for i = range j.stack {
+		path[i] = j.stack[i].elem
+	}
+	return path
+}
+
+// a value starts at the current position
+func (j *json_parser) start_value() {
+	if len(j.stack) > 0 && j.stack[len(j.stack)-1].array {
+		top := &j.stack[len(j.stack)-1]
+		top.elem = path_elem{index: top.count}
+		top.count++
+	}
+	if len(j.stack) > 0 {
+		j.nodes = append(j.nodes, path_node{
+			depth: len(j.stack),
+			path:  j.path(),
+		})
+	}
+	j.val = false
+}
+
+// determine the depth of a line, i.e., the number of containers open at
+// the start of the line, and the nodes starting on the line
+func (j *json_parser) depth(l []byte) (int, bool) {
+	d := len(j.stack)
+	j.nodes = j.nodes[:0]
+	if len(bytes.TrimSpace(l)) == 0 {
+		return d, true
+	}
+	if len(j.stack) == 0 {
+		j.val = true
+	}
+	var c byte
+	for _, c = range l {
+		if j.str {
+			if j.esc {
+				j.esc = false
+			} else if c == '\\' {
+				j.esc = true
+			} else if c == '"' {
+				j.str = false
+			}
+			j.buf = append(j.buf, c)
+			continue
+		}
+		switch c {
+		case '"':
+			j.str = true
+			j.buf = append(j.buf[:0], c)
+			if j.key {
+				j.key = false
+			} else if j.val {
+				j.start_value()
+			}
+		case ':':
+			if len(j.stack) > 0 {
+				key := string(j.buf)
+				if uq, err := strconv.Unquote(key); err == nil {
+					key = uq
 				} else {
-					break
+					key = strings.Trim(key, `"`)
 				}
+				j.stack[len(j.stack)-1].elem = path_elem{key: key, index: -1}
+			}
+			j.val = true
+		case ',':
+			j.key = len(j.stack) > 0 && !j.stack[len(j.stack)-1].array
+			j.val = !j.key
+		case '{', '[':
+			if j.val {
+				j.start_value()
+			}
+			j.stack = append(j.stack, json_container{array: c == '['})
+			j.key = c == '{'
+			j.val = c == '['
+		case '}', ']':
+			if len(j.stack) > 0 {
+				j.stack = j.stack[:len(j.stack)-1]
+			}
+			j.key = false
+			j.val = false
+		case ' ', '\t', '\r', '\n':
+		default:
+			if j.val {
+				j.start_value()
 			}
-			continue
 		}
-		if min_ind == -1 {
-			min_ind = sl.l_ind
-			new_sect = true
+	}
+	return d, false
+}
+
+// the nodes starting on the last line
+func (j *json_parser) line_nodes() []path_node {
+	return j.nodes
+}
+
+// a block of a hierarchical Junos configuration enclosing the current line
+type set_block struct {
+	stmt  string   // the statement starting the block
+	tags  []string // commands applying the tags of the statement
+	is    bool     // is the line starting the block selected?
+	label []byte   // label of the line starting the block
+}
+
+// a set command converted from a configuration line
+type set_command struct {
+	data  []byte
+	is    bool   // is the converted line selected?
+	label []byte // label of the section
+}
+
+// converter of hierarchical Junos configuration lines into set commands,
+// keeping track of the enclosing blocks
+type set_converter struct {
+	path []set_block // the enclosing blocks
+}
+
+// a command with the statements of all enclosing blocks and the given
+// statement as arguments
+func (c *set_converter) command(cmd, stmt string, eol []byte) []byte {
+	words := []string{cmd}
+	var b set_block
+	for _, b = range c.path {
+		words = append(words, b.stmt)
+	}
+	words = append(words, stmt)
+	return append([]byte(strings.Join(words, " ")), eol...)
+}
+
+// convert a configuration line into set commands with the given line
+// terminator, a statement results in a set command followed by commands
+// applying its tags, the end of a tagged block results in commands applying
+// the tags of the block, other lines do not result in any command
+func (c *set_converter) convert(l, eol []byte, is bool, label []byte) (cmds []set_command) {
+	quoted := false
+	var i int
+	var b byte
+	var stmt, tag string
+	var tags []string
+	for i = 0; i < len(l); i++ {
+		b = l[i]
+		if b == '"' {
+			quoted = !quoted
+		} else if quoted {
+			if b == '\\' {
+				i++
+			}
+		} else if b == '#' || (b == '/' && bytes.HasPrefix(l[i:], []byte("/*"))) {
+			// a comment
+			return nil
+		} else if b == '}' {
+			if len(c.path) == 0 {
+				return nil
+			}
+			blk := c.path[len(c.path)-1]
+			c.path = c.path[:len(c.path)-1]
+			for _, tag = range blk.tags {
+				cmds = append(cmds, set_command{
+					data:  c.command(tag, blk.stmt, eol),
+					is:    blk.is,
+					label: blk.label,
+				})
+			}
+			return
+		} else if b == '{' {
+			stmt, tags = set_statement(l[:i])
+			c.path = append(c.path, set_block{
+				stmt:  stmt,
+				tags:  tags,
+				is:    is,
+				label: label,
+			})
+			return nil
+		} else if b == ';' {
+			stmt, tags = set_statement(l[:i])
+			if stmt == "" {
+				return nil
+			}
+			for _, tag = range append([]string{"set"}, tags...) {
+				cmds = append(cmds, set_command{
+					data:  c.command(tag, stmt, eol),
+					is:    is,
+					label: label,
+				})
+			}
+			return
+		}
+	}
+	return nil
+}
+
+// tags in front of a statement, and the commands applying them
+var set_tags = [][2]string{
+	{"inactive:", "deactivate"},
+	{"protect:", "protect"},
+}
+
+// a statement without surrounding white space and without leading tags,
+// and the commands applying the tags
+func set_statement(b []byte) (string, []string) {
+	stmt := strings.TrimSpace(string(b))
+	var cmds []string
+	var tag [2]string
+	for _, tag = range set_tags {
+		if strings.HasPrefix(stmt, tag[0]) {
+			stmt = strings.TrimSpace(strings.TrimPrefix(stmt, tag[0]))
+			cmds = append(cmds, tag[1])
 		}
-		err = lm.act.print_line(&sl.data, sl.nr, new_sect, true)
+	}
+	return stmt, cmds
+}
+
+// line output converting lines of a hierarchical Junos configuration into
+// set commands when printing them, lines without a set command are dropped
+type set_output struct {
+	out  line_output
+	conv *set_converter // shared by the outputs for normal and ignored lines
+	tr   bool           // section transition of a dropped line
+}
+
+// print the set commands converted from a line, moving the transition of
+// a dropped line to the next printed line
+func (o *set_output) print_line(l *line, tr bool, is bool) error {
+	text := trim_eol(l.data)
+	cmds := o.conv.convert(text, l.data[len(text):], is, l.label)
+	if len(cmds) == 0 {
+		o.tr = o.tr || tr
+		return nil
+	}
+	// the commands keep the information about the input line
+	cl := *l
+	if cl.text == nil {
+		cl.text = l.data
+	}
+	var err error
+	var i int
+	for i = range cmds {
+		cl.data, cl.label = cmds[i].data, cmds[i].label
+		err = o.out.print_line(&cl, i == 0 && (tr || o.tr), cmds[i].is)
 		if err != nil {
 			break
 		}
-		new_sect = false
 	}
-	// all saved lines have been sent to a line printer
-	lm.lines = nil
-	return min_ind, err
+	o.tr = false
+	return err
 }
 
-// the "top level" section algorithm variant allows simpler handling
-// of saved lines than the generic .flush() implementation
-func (lm *top_level_lm) flush() (err error) {
-	// the last top level section is over, we do not have a match yet
-	lm.matched = false
-	if lm.lines == nil {
-		return
+// complete the output for the current input
+func (o *set_output) finish() error {
+	return o.out.finish()
+}
+
+// report a match in binary input
+func (o *set_output) print_binary_match() error {
+	return o.out.print_binary_match()
+}
+
+// select the character encoding of the output
+func (o *set_output) set_encoding(enc *text_encoding, bom bool) {
+	o.out.set_encoding(enc, bom)
+}
+
+// print an error message
+func (o *set_output) print_error(err error) {
+	o.out.print_error(err)
+}
+
+// node of a configuration tree reconstructed from set commands
+type set_node struct {
+	word     string
+	terminal bool            // does a set command end here?
+	tags     map[string]bool // tags applied by other commands, e.g., "inactive:"
+	children []*set_node
+}
+
+// find the child node for a word, adding it if it does not exist yet
+func (n *set_node) child(word string) *set_node {
+	var c *set_node
+	for _, c = range n.children {
+		if c.word == word {
+			return c
+		}
 	}
-	// send all saved lines to the appropriate line printer marked as
-	// "outside of a section"
-	new_sect := true
-	var l line
-	for _, l = range *lm.lines {
-		if l.l_ind == -1 {
-			err = lm.ign.print_line(&l.data, l.nr, false, false)
-			if err != nil {
-				break
+	c = &set_node{word: word}
+	n.children = append(n.children, c)
+	return c
+}
+
+// print the children of a node as hierarchical configuration, a chain of
+// nodes with one child each is combined into one statement, a tagged node
+// ends the statement, and its tags are put in front of the statement
+func (n *set_node) render(buf *bytes.Buffer, depth int) {
+	ind := strings.Repeat("    ", depth)
+	var c *set_node
+	var tag [2]string
+	for _, c = range n.children {
+		words := []string{c.word}
+		for !c.terminal && len(c.tags) == 0 && len(c.children) == 1 {
+			c = c.children[0]
+			words = append(words, c.word)
+		}
+		var tags []string
+		for _, tag = range set_tags {
+			if c.tags[tag[0]] {
+				tags = append(tags, tag[0])
+			}
+		}
+		stmt := strings.Join(append(tags, words...), " ")
+		if c.terminal {
+			buf.WriteString(ind + stmt + ";\n")
+			if len(c.children) > 0 {
+				// apply the tags only once
+				stmt = strings.Join(words, " ")
+			}
+		}
+		if len(c.children) > 0 {
+			buf.WriteString(ind + stmt + " {\n")
+			c.render(buf, depth+1)
+			buf.WriteString(ind + "}\n")
+		}
+	}
+}
+
+// split a set command into words, keeping quoted strings and bracketed
+// lists together
+func set_words(l string) []string {
+	var words []string
+	quoted := false
+	brackets := 0
+	start := -1
+	var i int
+	var b byte
+	for i = 0; i < len(l); i++ {
+		b = l[i]
+		if b == '"' {
+			quoted = !quoted
+		} else if quoted && b == '\\' {
+			i++
+			continue
+		} else if !quoted && b == '[' {
+			brackets++
+		} else if !quoted && b == ']' && brackets > 0 {
+			brackets--
+		} else if !quoted && brackets == 0 && (b == ' ' || b == '\t') {
+			if start > -1 {
+				words = append(words, l[start:i])
+				start = -1
 			}
 			continue
 		}
-		err = lm.act.print_line(&l.data, l.nr, new_sect, false)
-		if err != nil {
-			break
+		if start == -1 {
+			start = i
 		}
-		new_sect = false
 	}
-	lm.lines = nil
-	return
+	if start > -1 {
+		words = append(words, l[start:])
+	}
+	return words
 }
 
-// a collection of lines with added information for the "enclosing"
-// section algorithm
-type enclosing_lm struct {
-	simple_line_memory
+// read Junos set commands and return a hierarchical view of the
+// configuration, commands applying tags, e.g., "deactivate", result in
+// tagged statements, other lines are ignored
+func set_to_hierarchy(r io.Reader) (io.Reader, error) {
+	root := new(set_node)
+	lr := new_line_reader(r, 0)
+	var n *set_node
+	var word, applied string
+	var tag [2]string
+	for lr.next() {
+		words := set_words(string(lr.line))
+		if len(words) < 2 {
+			continue
+		}
+		applied = ""
+		for _, tag = range set_tags {
+			if words[0] == tag[1] {
+				applied = tag[0]
+			}
+		}
+		if words[0] != "set" && applied == "" {
+			continue
+		}
+		n = root
+		for _, word = range words[1:] {
+			n = n.child(word)
+		}
+		if applied == "" {
+			n.terminal = true
+		} else {
+			if n.tags == nil {
+				n.tags = make(map[string]bool)
+			}
+			n.tags[applied] = true
+		}
+	}
+	if lr.err != nil {
+		return nil, lr.err
+	}
+	var buf bytes.Buffer
+	root.render(&buf, 0)
+	return &buf, nil
 }
 
-// set the line printer for normal lines for "enclosing" implementation
-func (lm *enclosing_lm) set_act(lp *line_printer) {
-	lm.simple_line_memory.act = lp
+// reader converting lines prefixed with their ancestor lines back into
+// indented text, indenting by one space per level
+type unflatten_reader struct {
+	lr   *line_reader
+	sep  []byte
+	path [][]byte // the ancestor lines of the previous line
+	buf  []byte   // converted data not yet read
 }
 
-// set the line printer for normal lines for "enclosing" implementation
-func (lm *enclosing_lm) set_ign(lp *line_printer) {
-	lm.simple_line_memory.ign = lp
+// create a reader rebuilding indented text from flattened lines
+func new_unflatten_reader(r io.Reader, sep string) *unflatten_reader {
+	lr := new_line_reader(r, 0)
+	lr.report_idle = true
+	return &unflatten_reader{lr: lr, sep: []byte(sep)}
 }
 
-// also select headers in addition to enclosing section
-func (lm *enclosing_lm) add_headers() {
-	lm.simple_line_memory.with_headers = true
+// convert the next line, adding lines for new ancestors
+func (u *unflatten_reader) convert() {
+	eol := u.lr.raw[len(u.lr.line):]
+	nl := eol
+	if len(nl) == 0 {
+		nl = []byte("\n")
+	}
+	path := bytes.Split(u.lr.line, u.sep)
+	// skip ancestors already printed for previous lines
+	k := 0
+	for k < len(path)-1 && k < len(u.path) && bytes.Equal(path[k], u.path[k]) {
+		k++
+	}
+	for ; k < len(path); k++ {
+		u.buf = append(u.buf, bytes.Repeat([]byte(" "), k)...)
+		u.buf = append(u.buf, path[k]...)
+		if k < len(path)-1 {
+			u.buf = append(u.buf, nl...)
+		} else {
+			u.buf = append(u.buf, eol...)
+		}
+	}
+	u.path = u.path[:0]
+	var elem []byte
+	for _, elem = range path {
+		u.path = append(u.path, append([]byte(nil), elem...))
+	}
 }
 
-// query value of with_headers flag
-func (lm *enclosing_lm) get_with_headers() bool {
-	return lm.simple_line_memory.with_headers
+// read indented text converted from flattened lines
+func (u *unflatten_reader) Read(b []byte) (int, error) {
+	for len(u.buf) == 0 {
+		if !u.lr.next() {
+			if u.lr.idle {
+				return 0, err_idle
+			}
+			if u.lr.err != nil {
+				return 0, u.lr.err
+			}
+			return 0, io.EOF
+		}
+		u.convert()
+	}
+	n := copy(b, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
 }
 
-// add a line to the collection according to "enclosing" section rules
-func (lm *enclosing_lm) add(l *[]byte, nr uint64, l_ind, s_ind int) (int, error) {
-	var err error
-	_, err = lm.simple_line_memory.add(l, nr, l_ind, s_ind)
-	if err != nil {
-		return s_ind, err
+// input line of the tree used to sort, deduplicate, and merge sections
+type sort_line struct {
+	data []byte // the line including its line terminator
+	nr   uint64 // the line number in the input
+}
+
+// node of the input tree used to sort, deduplicate, and merge sections
+type sort_node struct {
+	pre      []sort_line // ignored lines in front of the header line
+	header   sort_line   // the line starting the section
+	ind      int         // indentation depth of the header line
+	children []*sort_node
+	post     []sort_line // ignored lines after the last child
+	no_eol   bool        // the input does not end with a line terminator
+}
+
+// write a node including its children, optionally with the ignored
+// lines in front of it, and collect the line numbers if requested
+func (n *sort_node) write(buf *bytes.Buffer, nrs *[]uint64, with_pre bool) {
+	write_line := func(l sort_line) {
+		buf.Write(l.data)
+		if nrs != nil {
+			*nrs = append(*nrs, l.nr)
+		}
 	}
-	// ignored lines do not affect section meta data
-	if l_ind == -1 {
-		return s_ind, err
+	var l sort_line
+	if with_pre {
+		for _, l = range n.pre {
+			write_line(l)
+		}
 	}
-	// only pattern match can affect section meta data
-	if l_ind != s_ind {
-		return s_ind, err
+	if n.header.data != nil {
+		write_line(n.header)
 	}
-	// extend a new section from the last preceding line with lower
-	// indentation level to the new line that was just added
-	nr_lines := len(*lm.lines)
-	// find section start: set i to number of possible section start
-	i := nr_lines - 1
-	for ; i > 0; i-- {
-		if (*lm.lines)[i].l_ind != -1 && (*lm.lines)[i].l_ind < s_ind {
-			break
+	var c *sort_node
+	for _, c = range n.children {
+		c.write(buf, nrs, true)
+	}
+	for _, l = range n.post {
+		write_line(l)
+	}
+}
+
+// the sort key of a section, i.e., the header line without surrounding
+// white space, or the first submatch of the sort key regular expression
+func (p *section_params) sort_key(n *sort_node) string {
+	key := bytes.TrimSpace(n.header.data)
+	if p.sort_key_re != nil {
+		m := p.sort_key_re.FindSubmatch(key)
+		if len(m) > 1 {
+			key = m[1]
+		} else if len(m) == 1 {
+			key = m[0]
 		}
 	}
-	// determine section indentation level
-	if (*lm.lines)[i].l_ind != -1 {
-		// line in position i starts the section
-		s_ind = (*lm.lines)[i].l_ind
-	} else {
-		// line matching pattern starts the section
-		return s_ind, err
+	return string(key)
+}
+
+// compare strings treating sequences of digits as numbers
+func natural_less(a, b string) bool {
+	for len(a) > 0 && len(b) > 0 {
+		if is_digit(a[0]) && is_digit(b[0]) {
+			i, j := 0, 0
+			for i < len(a) && is_digit(a[i]) {
+				i++
+			}
+			for j < len(b) && is_digit(b[j]) {
+				j++
+			}
+			na := strings.TrimLeft(a[:i], "0")
+			nb := strings.TrimLeft(b[:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
 	}
-	// mark lines comprising section with newly found indentation level
-	for ; i < nr_lines; i++ {
-		(*lm.lines)[i].s_ind = s_ind
-		(*lm.lines)[i].selected = true
+	return len(a) < len(b)
+}
+
+// is the byte an ASCII digit?
+func is_digit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// a number in a sort key
+var sort_number_re = regexp.MustCompile(`[-+]?[0-9]+(\.[0-9]+)?`)
+
+// the first number in a sort key, 0 if there is none
+func sort_number(key string) float64 {
+	f, _ := strconv.ParseFloat(sort_number_re.FindString(key), 64)
+	return f
+}
+
+// sort and deduplicate the sections at the given depth below a node
+func (p *section_params) reorder(n *sort_node, depth int) {
+	var c *sort_node
+	if depth > 0 {
+		for _, c = range n.children {
+			p.reorder(c, depth-1)
+		}
+		return
 	}
-	return s_ind, err
+	if p.uniq_sections {
+		seen := make(map[string]bool)
+		var uniq []*sort_node
+		var buf bytes.Buffer
+		for _, c = range n.children {
+			buf.Reset()
+			c.write(&buf, nil, false)
+			if !seen[buf.String()] {
+				seen[buf.String()] = true
+				uniq = append(uniq, c)
+			}
+		}
+		n.children = uniq
+	}
+	if p.sort_order == SORT_NONE {
+		return
+	}
+	keys := make(map[*sort_node]string, len(n.children))
+	for _, c = range n.children {
+		keys[c] = p.sort_key(c)
+	}
+	sort.SliceStable(n.children, func(i, j int) bool {
+		a, b := keys[n.children[i]], keys[n.children[j]]
+		if p.sort_order == SORT_NATURAL {
+			return natural_less(a, b)
+		} else if p.sort_order == SORT_NUMERIC {
+			return sort_number(a) < sort_number(b)
+		}
+		return a < b
+	})
 }
 
-// use .flush() method from the generic implementation of the simple
-// ("memoryless") section algorithm line memory for "enclosing"
-func (lm *enclosing_lm) flush() (err error) {
-	return lm.simple_line_memory.flush()
+// read the complete input into a tree of sections determined by
+// indentation, ignored lines stay in front of the following section
+func (p *section_params) read_tree(r io.Reader) (*sort_node, error) {
+	root := &sort_node{ind: -1}
+	stack := []*sort_node{root}
+	var pending []sort_line
+	buf := buffer_limit{max: p.max_buffer}
+	lr := new_line_reader(r, 0)
+	var nr uint64
+	var err error
+	// YAML structure determines the depth of a line
+	var yp structure_parser
+	if p.key_path != nil && is_json(lr.first_block()) {
+		yp = new(json_parser)
+	} else if p.key_path != nil || p.yaml {
+		yp = new(yaml_parser)
+	}
+	for lr.next() {
+		nr++
+		l := sort_line{data: append([]byte(nil), lr.raw...), nr: nr}
+		// a moved last line needs a line terminator
+		root.no_eol = len(lr.raw) == len(lr.line)
+		if root.no_eol {
+			l.data = append(l.data, '\n')
+		}
+		err = buf.grow(len(l.data))
+		if err != nil {
+			return nil, err
+		}
+		ind, ign := 0, false
+		if yp != nil {
+			ind, ign = yp.depth(lr.line)
+		} else {
+			ind = p.line_depth(lr.line)
+		}
+		if ign || p.ignored_line(lr.line) {
+			pending = append(pending, l)
+			continue
+		}
+		n := &sort_node{pre: pending, header: l, ind: ind}
+		pending = nil
+		for len(stack) > 1 && stack[len(stack)-1].ind >= n.ind {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, n)
+		stack = append(stack, n)
+	}
+	if lr.err != nil {
+		return nil, lr.err
+	}
+	root.post = pending
+	return root, nil
 }
 
-// print error with prefix
-func print_err(err error) {
-	log.SetPrefix(PROG + ": error: ")
-	log.Print(err)
+// read the complete input and return it with sorted and deduplicated
+// sections, together with the original line numbers of the lines
+func (p *section_params) sort_input(r io.Reader) (io.Reader, []uint64, error) {
+	root, err := p.read_tree(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.reorder(root, p.sort_depth)
+	var out bytes.Buffer
+	var nrs []uint64
+	root.write(&out, &nrs, false)
+	// keep a missing line terminator at the end of the input
+	if root.no_eol && out.Len() > 0 {
+		out.Truncate(out.Len() - 1)
+	}
+	return &out, nrs, nil
 }
 
-// print short usage information
-func usage(w io.Writer) {
-	fmt.Fprintf(w, "Usage: %s [OPTION...] PATTERN [FILE...]\n", PROG)
+// strategies for merging sections with matching header lines
+const (
+	MERGE_REPLACE = iota
+	MERGE_APPEND_CHILDREN
+	MERGE_RECURSIVE
+)
+
+// names of the merge strategies
+var merge_strategies = map[string]int{
+	"replace":         MERGE_REPLACE,
+	"append-children": MERGE_APPEND_CHILDREN,
+	"recursive-merge": MERGE_RECURSIVE,
 }
 
-// print error with prefix, print short usage info, then exit with code 2
-func usage_err(err error) {
-	log.SetPrefix(PROG + ": error: ")
-	log.Print(err)
-	usage(os.Stderr)
-	fmt.Fprintf(os.Stderr, "Try '%s --help' for more information\n", PROG)
-	os.Exit(2)
+// find the child with the given header line, ignoring surrounding white
+// space
+func (n *sort_node) find(header []byte) *sort_node {
+	header = bytes.TrimSpace(header)
+	var c *sort_node
+	for _, c = range n.children {
+		if bytes.Equal(bytes.TrimSpace(c.header.data), header) {
+			return c
+		}
+	}
+	return nil
 }
 
-// print help
-func help() {
-	version()
-	fmt.Println("")
-	fmt.Println(PROG, DESC)
-	usage(os.Stdout)
-	fmt.Println("Options:")
-	flag.CommandLine.SetOutput(os.Stdout)
-	flag.PrintDefaults()
+// replace the indentation prefix from with to in the header line and
+// the ignored lines of a node, less indented lines stay unchanged
+func (n *sort_node) shift(from, to []byte) {
+	shift_line := func(l *sort_line) {
+		if l.data != nil && bytes.HasPrefix(l.data, from) {
+			d := append([]byte(nil), to...)
+			l.data = append(d, l.data[len(from):]...)
+		}
+	}
+	var i int
+	for i = range n.pre {
+		shift_line(&n.pre[i])
+	}
+	shift_line(&n.header)
+	for i = range n.post {
+		shift_line(&n.post[i])
+	}
+}
+
+// the indentation of a line
+func (p *section_params) indentation(l []byte) []byte {
+	return append([]byte(nil), p.ind_re.Find(l)...)
+}
+
+// the indentation added by the first nested section, nil if there is none
+func (p *section_params) indent_step(n *sort_node) []byte {
+	var c *sort_node
+	for _, c = range n.children {
+		if n.header.data != nil {
+			ind, ci := p.indentation(n.header.data), p.indentation(c.header.data)
+			if len(ci) > len(ind) && bytes.HasPrefix(ci, ind) {
+				return ci[len(ind):]
+			}
+		}
+		step := p.indent_step(c)
+		if step != nil {
+			return step
+		}
+	}
+	return nil
 }
 
-// print version and copyright information
-func version() {
-	fmt.Println(PROG, "version", VERSION)
-	fmt.Println(COPYRIGHT)
+// merging state
+type merger struct {
+	p        *section_params
+	strategy int
+	step     []byte // indentation added by a nested section of the base
 }
 
-// compute indentation depth from indentation byte sequence
-func indentation_depth(in *[]byte, ts int, tab_is_n_spaces bool) int {
-	if in == nil {
-		return 0
+// append a section of the overlay to a base node, re-indented like the
+// sections of the base, parent_ind is the indentation of the overlay
+// parent section
+func (m *merger) adopt(base *sort_node, parent_ind []byte, c *sort_node) {
+	from := m.p.indentation(c.header.data)
+	var to []byte
+	if len(base.children) > 0 {
+		to = m.p.indentation(base.children[0].header.data)
+	} else if base.header.data == nil {
+		to = from
+	} else if m.step != nil {
+		to = append(m.p.indentation(base.header.data), m.step...)
+	} else if bytes.HasPrefix(from, parent_ind) {
+		// keep the indentation relative to the parent section
+		to = append(m.p.indentation(base.header.data), from[len(parent_ind):]...)
+	} else {
+		to = from
 	}
-	if ts < 2 {
-		return len(*in)
+	c.shift(from, to)
+	children := c.children
+	c.children = nil
+	var cc *sort_node
+	for _, cc = range children {
+		m.adopt(c, from, cc)
 	}
-	d := 0
-	var c byte
-	for _, c = range *in {
-		if c == '\t' {
-			if tab_is_n_spaces {
-				d += ts
-			} else {
-				d += ts - (d % ts)
+	base.children = append(base.children, c)
+}
+
+// merge the children of an overlay node into a base node
+func (m *merger) merge(base, overlay *sort_node) {
+	overlay_ind := m.p.indentation(overlay.header.data)
+	var o, b, c *sort_node
+	for _, o = range overlay.children {
+		b = base.find(o.header.data)
+		if b == nil {
+			m.adopt(base, overlay_ind, o)
+			continue
+		}
+		switch m.strategy {
+		case MERGE_REPLACE:
+			ind := m.p.indentation(o.header.data)
+			o.shift(ind, m.p.indentation(b.header.data))
+			b.header = o.header
+			b.post = o.post
+			b.children = nil
+			for _, c = range o.children {
+				m.adopt(b, ind, c)
+			}
+		case MERGE_APPEND_CHILDREN:
+			ind := m.p.indentation(o.header.data)
+			for _, c = range o.children {
+				m.adopt(b, ind, c)
 			}
+		case MERGE_RECURSIVE:
+			m.merge(b, o)
+		}
+	}
+}
+
+// print the base file merged with the overlay files, return the exit code
+func merge_files(p section_params, names []string, strategy int) int {
+	var root *sort_node
+	var m merger
+	var name string
+	for _, name = range names {
+		f, err := os.Open(name)
+		if err != nil {
+			print_err(err)
+			return 2
+		}
+		r, _, _ := decode_input(f, p.encoding)
+		tree, err := p.read_tree(r)
+		f.Close()
+		if err != nil {
+			print_err(err)
+			return 2
+		}
+		if root == nil {
+			root = tree
+			m = merger{p: &p, strategy: strategy, step: p.indent_step(tree)}
 		} else {
-			d++
+			m.merge(root, tree)
 		}
 	}
-	return d
+	var out bytes.Buffer
+	root.write(&out, nil, false)
+	_, err := os.Stdout.Write(out.Bytes())
+	if err != nil {
+		print_err(err)
+		return 2
+	}
+	return 0
 }
 
 // read input text and write matching sections to output
@@ -654,46 +5418,164 @@ func section(p section_params, r io.Reader) (matched bool, err error) {
 	s_ind := -1        // indentation depth of current section
 	c_ind := -1        // indentation depth of current line
 	min_ind := -1      // minimal indentation level seen so far
-	ind_off := 0       // start of indentation offset
-	var buf []byte     // buffer space to hold input data
 	var l []byte       // one line of input data
-	var li []byte      // indentation bytes of the line
+	var lo []byte      // the line as given to the line memory for output
 	var l_nr uint64    // current line number
+	var l_nrs []uint64 // original line numbers of reordered input
+	var y_ind int      // structural depth of a YAML line
+	var y_ign bool     // is this YAML line ignored as a section boundary?
+	var yp structure_parser
 
+	// the filter determines if a section matches in the end
+	if p.filter != nil {
+		p.filter.passed = false
+	}
 	// process input line by line
-	s := bufio.NewScanner(r)
-	s.Buffer(buf, ARB_BUF_LIM)
-	for s.Scan() {
-		l_nr++
-		l = s.Bytes()
+	// convert input to UTF-8
+	r, enc, bom := decode_input(r, p.encoding)
+	if p.reencode {
+		p.output.set_encoding(enc, bom)
+	}
+	// Junos set commands are converted to a hierarchical configuration
+	if p.input_format == INPUT_SET {
+		r, err = set_to_hierarchy(r)
+		if err != nil {
+			p.output.print_error(err)
+			return
+		}
+	}
+	// flattened lines are converted to indented text
+	if p.unflatten {
+		r = new_unflatten_reader(r, p.flatten_sep)
+	}
+	// sorting and deduplicating sections needs the complete input
+	if p.sort_order != SORT_NONE || p.uniq_sections {
+		r, l_nrs, err = p.sort_input(r)
+		if err != nil {
+			p.output.print_error(err)
+			return
+		}
+	}
+	s := new_line_reader(r, p.max_line_length)
+	s.report_idle = true
+	// binary input is not printed, but a match is reported
+	binary := false
+	if p.binary_files != BINARY_FILES_TEXT && is_binary(s.first_block(), p.binary_utf8) {
+		if p.binary_files == BINARY_FILES_WITHOUT_MATCH {
+			return
+		}
+		binary = true
+		p.memory = p.new_memory(false, false, &line_printer{quiet: true})
+	}
+	// key paths address JSON or YAML documents
+	if p.key_path != nil && is_json(s.first_block()) {
+		yp = new(json_parser)
+	} else if p.key_path != nil || p.yaml {
+		yp = new(yaml_parser)
+	}
+	for s.next() || s.idle {
+		// idle input prints pending lines, but following lines may
+		// still continue the current sections
+		if s.idle {
+			err = p.memory.flush()
+			if err != nil {
+				p.output.print_error(err)
+				return
+			}
+			continue
+		}
+		// reordered lines keep their original line numbers
+		if len(l_nrs) > 0 {
+			l_nr, l_nrs = l_nrs[0], l_nrs[1:]
+		} else {
+			l_nr++
+		}
+		l = s.line
+		// the output may need the original line terminator
+		lo = l
+		if p.preserve_eol {
+			lo = s.raw
+		}
+		// lines exceeding the maximum line length need special treatment
+		if s.long {
+			if p.long_lines == LONG_LINES_SKIP {
+				continue
+			} else if p.long_lines == LONG_LINES_ERROR {
+				// print the lines preceding the long line
+				err = p.memory.flush()
+				if err != nil {
+					p.output.print_error(err)
+					return
+				}
+				err = fmt.Errorf("line %d exceeds --max-line-length of %d bytes",
+					l_nr, p.max_line_length)
+				p.output.print_error(err)
+				return
+			}
+		}
+		// YAML structure needs to consider every line
+		if yp != nil {
+			y_ind, y_ign = yp.depth(l)
+		}
 		// ignored lines do not cause a section transition
 		if p.ignore_re != nil && p.ignore_re.Match(l) {
-			_, err = p.memory.add(&l, l_nr, -1, s_ind)
+			_, err = p.memory.add(&lo, l_nr, -1, false, s_ind)
 			if err != nil {
-				print_err(err)
+				p.output.print_error(err)
+				return
+			}
+			continue
+		}
+		// comment lines do not cause a section transition either, but
+		// a comment line matching the pattern is selected even outside
+		// of a section
+		if p.comment_re != nil && p.comment_re.Match(l) {
+			pat_match = !p.no_match_comments && p.match(l)
+			if pat_match {
+				matched = true
+			}
+			s_ind, err = p.memory.add_comment(&lo, l_nr, pat_match, s_ind)
+			if err != nil {
+				p.output.print_error(err)
+				return
+			}
+			in_sect = s_ind > -1
+			continue
+		}
+		// YAML blank and comment lines do not cause a section transition
+		if y_ign {
+			_, err = p.memory.add(&lo, l_nr, -1, false, s_ind)
+			if err != nil {
+				p.output.print_error(err)
 				return
 			}
 			continue
 		}
 		// determine indentation depth of current line
-		if p.ignore_prefix_re != nil {
-			start_end := p.ignore_prefix_re.FindIndex(l)
-			// prefix must start at beginning of line
-			if start_end != nil && start_end[0] == 0 {
-				ind_off = start_end[1]
-			} else {
-				ind_off = 0
+		if yp != nil {
+			c_ind = y_ind
+		} else {
+			c_ind = p.line_depth(l)
+		}
+		// a key path addresses a node, which may start inside the line
+		if p.key_path != nil {
+			pat_match = false
+			for _, n := range yp.line_nodes() {
+				if p.key_path.match(n.path) {
+					pat_match = true
+					c_ind = n.depth
+					break
+				}
 			}
+			pat_match = pat_match != p.invert_match
 		}
-		li = p.ind_re.Find(l[ind_off:])
-		c_ind = indentation_depth(&li, p.tab_size, p.tab_is_n_spaces)
 		// manage top level section status
 		if min_ind > -1 && c_ind <= min_ind {
 			// print a completed top level section
 			min_ind = c_ind
 			err = p.memory.flush()
 			if err != nil {
-				print_err(err)
+				p.output.print_error(err)
 				return
 			}
 		} else if min_ind == -1 {
@@ -701,9 +5583,8 @@ func section(p section_params, r io.Reader) (matched bool, err error) {
 			min_ind = c_ind
 		}
 		// check if current line matches pattern
-		pat_match = p.pat_re.Match(l)
-		if p.invert_match {
-			pat_match = !pat_match
+		if p.key_path == nil {
+			pat_match = p.match(l)
 		}
 		// is the current line a continuation of a section?
 		cont_sect = in_sect && (c_ind > s_ind)
@@ -717,25 +5598,138 @@ func section(p section_params, r io.Reader) (matched bool, err error) {
 				s_ind = -1
 			}
 		}
+		// a section started by a pattern match labels its lines
+		if p.label_tmpl != nil && !cont_sect {
+			var label []byte
+			if pat_match {
+				label = p.section_label(l)
+			}
+			p.memory.set_label(label)
+		}
 		// add current line to memory
-		s_ind, err = p.memory.add(&l, l_nr, c_ind, s_ind)
+		// (the line memory may start or extend a section)
+		s_ind, err = p.memory.add(&lo, l_nr, c_ind, pat_match, s_ind)
 		if err != nil {
-			print_err(err)
+			p.output.print_error(err)
 			return
 		}
+		in_sect = s_ind > -1
 	}
-	// print last top level section
+	// print last top level section, keeping the first error for the
+	// exit code
+	var e error
 	err = p.memory.flush()
 	if err != nil {
-		print_err(err)
+		p.output.print_error(err)
 	}
-	err = s.Err()
-	if err != nil {
-		print_err(err)
+	if !binary {
+		e = p.output.finish()
+		if e != nil {
+			p.output.print_error(e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	if p.filter != nil {
+		matched = p.filter.passed
+	}
+	if s.err != nil {
+		p.output.print_error(s.err)
+		if err == nil {
+			err = s.err
+		}
+	}
+	if binary && matched {
+		e = p.output.print_binary_match()
+		if e != nil {
+			p.output.print_error(e)
+			if err == nil {
+				err = e
+			}
+		}
 	}
 	return
 }
 
+// result of processing one file concurrently with other files
+type file_job struct {
+	name     string
+	rec      print_recorder
+	m        bool
+	err      error // error already recorded by section()
+	open_err error // error to print when printing the results
+	done     chan bool
+}
+
+// process one file with its own line memory and recording line output
+func (j *file_job) run(sp section_params, lp *line_printer) {
+	defer close(j.done)
+	f, err := os.Open(j.name)
+	if err != nil {
+		j.open_err = err
+		return
+	}
+	defer f.Close()
+	j.rec.begin = lp.begin
+	j.rec.omit = lp.omit
+	j.rec.quiet = lp.quiet
+	// templates may need lines that are not printed
+	_, j.rec.all = lp.formatter.(*template_formatter)
+	// the filter keeps state per file
+	if sp.filter != nil {
+		filter := *sp.filter
+		sp.filter = &filter
+	}
+	sp.memory = sp.new_memory(lp.begin, lp.omit, &j.rec)
+	sp.output = &j.rec
+	j.m, j.err = section(sp, f)
+}
+
+// process up to n files concurrently, printing the results in argument
+// order, and return the updated exit code
+func section_files_parallel(sp section_params, lp *line_printer, names []string, n int, ec int) int {
+	jobs := make([]*file_job, len(names))
+	var i int
+	for i = range names {
+		jobs[i] = &file_job{name: names[i], done: make(chan bool)}
+	}
+	// a job slot is freed only after printing the job's results, this
+	// limits the amount of buffered output
+	slots := make(chan bool, n)
+	go func() {
+		var j *file_job
+		for _, j = range jobs {
+			slots <- true
+			go j.run(sp, lp)
+		}
+	}()
+	var j *file_job
+	var err error
+	for _, j = range jobs {
+		<-j.done
+		lp.filename = j.name
+		lp.has_printed_file = false
+		if lp.begin {
+			lp.select_rest = false
+		}
+		if j.open_err != nil {
+			print_err(j.open_err)
+			ec = exit_code(ec, false, j.open_err)
+			<-slots
+			continue
+		}
+		err = j.rec.replay(lp)
+		if err != nil {
+			print_err(err)
+			j.err = err
+		}
+		ec = exit_code(ec, j.m, j.err)
+		<-slots
+	}
+	return ec
+}
+
 // exit code 2 if an error occurred
 // exit code 1 without match nor error
 // exit code 0 on match without error
@@ -760,11 +5754,14 @@ func main() {
 	}
 	// default line printer
 	lp := line_printer{
+		line_end:              "\n",
+		out:                   os.Stdout,
 		file_header_prefix:    DEF_FILE_HEADER_PREFIX,
 		file_header_suffix:    DEF_FILE_HEADER_SUFFIX,
 		file_separator_string: DEF_FILE_SEPARATOR_STRING,
 		separator_string:      DEF_SEPARATOR,
 		filename:              "",
+		formatter:             prefix_formatter{},
 	}
 
 	// error logging
@@ -774,15 +5771,34 @@ func main() {
 	// define command line flags
 	flag.Usage = func() { usage_err(errors.New("unknown option")) }
 	// print program information instead of sections
-	var print_help, print_version bool
+	var print_help, print_profiles, print_version bool
+	// default options from configuration file and environment variable
+	var config string
+	var no_config bool
+	flag.StringVar(&config, "config", config_path(), OD_CONFIG)
+	flag.BoolVar(&no_config, "no-config", false, OD_NO_CONFIG)
 	flag.BoolVar(&print_help, "help", false, OD_HELP)
 	flag.BoolVar(&print_help, "h", false, OD_HELP)
 	flag.BoolVar(&print_version, "version", false, OD_VERSION)
 	flag.BoolVar(&print_version, "V", false, OD_VERSION)
+	flag.BoolVar(&print_profiles, "list-profiles", false, OD_LIST_PROFILES)
 	// modify section behavior
-	var ignore_prefix_re, ignore_re, indent_re string
+	var comment_re, ignore_prefix_re, ignore_re, indent_re, prof string
 	flag.BoolVar(&lp.begin, "begin", false, OD_BEGIN)
+	var binary_files string
+	flag.StringVar(&binary_files, "binary-files", DEF_BINARY_FILES,
+		OD_BINARY_FILES)
+	flag.StringVar(&comment_re, "comment-re", "", OD_COMMENT_RE)
+	var contains, not_contains string_list
+	flag.Var(&contains, "contains", OD_CONTAINS)
+	var columns string_list
+	flag.Var(&columns, "column", OD_COLUMN)
+	var contains_ip_in, contains_ip_equals string_list
+	flag.Var(&contains_ip_in, "contains-ip-in", OD_CONTAINS_IP_IN)
+	flag.Var(&contains_ip_equals, "contains-ip-equals", OD_CONTAINS_IP_EQUALS)
 	flag.BoolVar(&sp.enclosing, "enclosing", false, OD_ENCLOSING)
+	var encoding string
+	flag.StringVar(&encoding, "encoding", DEF_ENCODING, OD_ENCODING)
 	flag.BoolVar(&lp.file_header, "file-header", false, OD_FILE_HEADER)
 	flag.StringVar(&lp.file_header_prefix, "file-header-prefix",
 		DEF_FILE_HEADER_PREFIX, OD_FILE_HEADER_PREFIX)
@@ -794,34 +5810,102 @@ func main() {
 		DEF_FILE_SEPARATOR_STRING, OD_FILE_SEPARATOR_STRING)
 	flag.BoolVar(&sp.fixed_string, "fixed-string", false, OD_FIXED_STRING)
 	flag.BoolVar(&sp.fixed_string, "F", false, OD_FIXED_STRING)
+	var follow bool
+	var idle_timeout time.Duration
+	flag.BoolVar(&follow, "follow", false, OD_FOLLOW)
+	flag.BoolVar(&follow, "f", false, OD_FOLLOW)
+	flag.BoolVar(&sp.flatten, "flatten", false, OD_FLATTEN)
+	flag.StringVar(&sp.flatten_sep, "flatten-separator", DEF_FLATTEN_SEPARATOR,
+		OD_FLATTEN_SEPARATOR)
+	flag.IntVar(&sp.following_siblings, "following-siblings", 0,
+		OD_FOLLOWING_SIBLINGS)
 	flag.BoolVar(&sp.headers, "headers", false, OD_HEADERS)
 	flag.BoolVar(&sp.ignore_blank, "ignore-blank", false, OD_IGNORE_BLANK)
 	flag.BoolVar(&sp.ignore_case, "ignore-case", false, OD_IGNORE_CASE)
 	flag.BoolVar(&sp.ignore_case, "i", false, OD_IGNORE_CASE)
 	flag.StringVar(&ignore_prefix_re, "ignore-prefix", "", OD_IGNORE_PREFIX)
+	flag.DurationVar(&idle_timeout, "idle-timeout", DEF_IDLE_TIMEOUT,
+		OD_IDLE_TIMEOUT)
 	flag.StringVar(&ignore_re, "ignore-re", "", OD_IGNORE_RE)
 	flag.StringVar(&indent_re, "indent-re", DEF_IND_RE, OD_INDENT_RE)
+	var indent_width string
+	flag.StringVar(&indent_width, "indent-width", DEF_INDENT_WIDTH,
+		OD_INDENT_WIDTH)
 	flag.BoolVar(&sp.invert_match, "invert-match", false, OD_INVERT_MATCH)
+	var jobs int
+	flag.IntVar(&jobs, "jobs", 1, OD_JOBS)
+	flag.IntVar(&jobs, "j", 1, OD_JOBS)
 	flag.StringVar(&sp.stdin_label, "label", DEF_STDIN_LABEL,
 		OD_STDIN_LABEL)
+	var label_section string
+	flag.StringVar(&label_section, "label-section", "", OD_LABEL_SECTION)
+	var line_endings string
+	flag.StringVar(&line_endings, "line-endings", DEF_LINE_ENDINGS,
+		OD_LINE_ENDINGS)
+	var input string
+	flag.StringVar(&input, "input", DEF_INPUT, OD_INPUT)
+	var iface_str string
+	flag.StringVar(&iface_str, "interface", "", OD_INTERFACE)
+	var ip_in, ip_equals string
+	flag.StringVar(&ip_equals, "ip-equals", "", OD_IP_EQUALS)
+	flag.StringVar(&ip_in, "ip-in", "", OD_IP_IN)
+	var key_path_str string
+	flag.StringVar(&key_path_str, "key-path", "", OD_KEY_PATH)
 	flag.BoolVar(&lp.line_number, "line-number", false, OD_LINE_NUMBER)
 	flag.BoolVar(&lp.line_number, "n", false, OD_LINE_NUMBER)
+	var long_lines string
+	flag.StringVar(&long_lines, "long-lines", DEF_LONG_LINES, OD_LONG_LINES)
+	var max_buffer, max_line_length string
+	flag.StringVar(&max_buffer, "max-buffer", "", OD_MAX_BUFFER)
+	flag.StringVar(&max_line_length, "max-line-length", "",
+		OD_MAX_LINE_LENGTH)
+	flag.BoolVar(&sp.no_match_comments, "no-match-comments", false,
+		OD_NO_MATCH_COMMENTS)
+	flag.Var(&not_contains, "not-contains", OD_NOT_CONTAINS)
 	flag.BoolVar(&lp.omit, "omit", false, OD_OMIT)
 	flag.BoolVar(&sp.omit_ignored, "omit-ignored", false, OD_OMIT_IGNORED)
+	// merging combines files instead of selecting sections
+	var merge bool
+	flag.BoolVar(&merge, "merge", false, OD_MERGE)
+	var merge_strategy string
+	flag.StringVar(&merge_strategy, "merge-strategy", DEF_MERGE_STRATEGY,
+		OD_MERGE_STRATEGY)
+	var output string
+	flag.StringVar(&output, "output", DEF_OUTPUT, OD_OUTPUT)
+	flag.IntVar(&sp.parent, "parent", 0, OD_PARENT)
+	var format, section_format string
+	flag.StringVar(&format, "format", "", OD_FORMAT)
+	flag.StringVar(&section_format, "section-format", "", OD_SECTION_FORMAT)
 	flag.StringVar(&lp.prefix_delim, "prefix-delimiter", DEF_PREFIX_DELIM,
 		OD_PREFIX_DELIM)
+	flag.StringVar(&prof, "profile", "", OD_PROFILE)
 	flag.BoolVar(&lp.quiet, "quiet", false, OD_QUIET)
 	flag.BoolVar(&lp.quiet, "q", false, OD_QUIET)
 	flag.BoolVar(&lp.quiet, "silent", false, OD_QUIET)
+	flag.BoolVar(&sp.reencode, "reencode", false, OD_REENCODE)
 	flag.BoolVar(&lp.separator, "separator", false, OD_SEPARATOR)
 	flag.StringVar(&lp.separator_string, "separator-string", DEF_SEPARATOR,
 		OD_SEPARATOR_STRING)
+	flag.BoolVar(&sp.siblings, "siblings", false, OD_SIBLINGS)
+	flag.IntVar(&sp.sort_depth, "sort-depth", 0, OD_SORT_DEPTH)
+	var sort_key string
+	flag.StringVar(&sort_key, "sort-key", "", OD_SORT_KEY)
+	sort_sections := optional_string{def: DEF_SORT_SECTIONS}
+	flag.Var(&sort_sections, "sort-sections", OD_SORT_SECTIONS)
+	var table string
+	flag.StringVar(&table, "table", "", OD_TABLE)
 	flag.BoolVar(&sp.tab_is_n_spaces, "tab-is-n-spaces", false,
 		OD_TAB_IS_N_SPACES)
 	flag.IntVar(&sp.tab_size, "tab-size", 8, OD_TAB_SIZE)
+	var text bool
+	flag.BoolVar(&text, "text", false, OD_TEXT)
+	flag.BoolVar(&text, "a", false, OD_TEXT)
 	flag.BoolVar(&sp.top_level, "top-level", false, OD_TOP_LEVEL)
+	flag.BoolVar(&sp.unflatten, "unflatten", false, OD_UNFLATTEN)
+	flag.BoolVar(&sp.uniq_sections, "uniq-sections", false, OD_UNIQ_SECTIONS)
 	flag.BoolVar(&lp.with_filename, "with-filename", false,
 		OD_WITH_FILENAME)
+	flag.BoolVar(&sp.yaml, "yaml", false, OD_YAML)
 	flag.BoolVar(&sp.yaml_ind, "yaml-seq-indent", false, OD_YAML_IND)
 	// parse command line flags
 	flag.Parse()
@@ -835,6 +5919,32 @@ func main() {
 		version()
 		os.Exit(0)
 	}
+	explicit := false
+	cmd_prof := ""
+	flag.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "config"
+		if f.Name == "profile" {
+			cmd_prof = prof
+		}
+	})
+	if !no_config {
+		err = apply_defaults(config, explicit, cmd_prof)
+		if err != nil {
+			usage_err(err)
+		}
+	}
+	if print_profiles {
+		list_profiles()
+		os.Exit(0)
+	}
+	// a profile given on the command line takes precedence over the default
+	// options, a profile set by the default options does not
+	if prof != "" && (cmd_prof == "" || no_config) {
+		err = apply_profile(prof)
+		if err != nil {
+			usage_err(err)
+		}
+	}
 	// section parameters
 	if sp.ignore_blank {
 		sp.ignore_re = regexp.MustCompile(BLANK_RE)
@@ -845,6 +5955,16 @@ func main() {
 			usage_err(errors.New("invalid --ignore-re argument"))
 		}
 	}
+	if comment_re != "" {
+		if preset, ok := comment_presets[comment_re]; ok {
+			comment_re = preset
+		}
+		sp.comment_re, err = regexp.Compile(comment_re)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --comment-re argument"))
+		}
+	}
 	if ignore_prefix_re != "" {
 		if ignore_prefix_re[0] != '^' {
 			ignore_prefix_re = "^" + ignore_prefix_re
@@ -858,69 +5978,345 @@ func main() {
 	if sp.yaml_ind {
 		sp.ind_re = regexp.MustCompile(YAML_IND_RE)
 	} else {
+		// indentation measured in runes or columns may use any Unicode
+		// space character, unless an indentation regular expression
+		// is given
+		ind_re_given := false
+		flag.Visit(func(f *flag.Flag) {
+			ind_re_given = ind_re_given || f.Name == "indent-re"
+		})
+		if !ind_re_given && indent_width_units[indent_width] != INDENT_WIDTH_BYTES {
+			indent_re = DEF_IND_RE_UNICODE
+		}
 		sp.ind_re, err = regexp.Compile(indent_re)
 		if err != nil {
 			print_err(err)
 			usage_err(errors.New("invalid --indent-re argument:"))
 		}
 	}
-	// line memory selection (also affected by --headers)
-	if sp.top_level || (sp.headers && lp.begin) {
-		sp.memory = new(top_level_lm)
-	} else if sp.enclosing {
-		sp.memory = new(enclosing_lm)
-	} else if sp.headers {
-		sp.memory = new(simple_line_memory)
+	// patterns to filter sections by their contents
+	if len(contains) > 0 || len(not_contains) > 0 ||
+		len(contains_ip_in) > 0 || len(contains_ip_equals) > 0 {
+		sp.filter = new(body_filter)
+		sp.filter.contains, err = compile_patterns(contains, sp.fixed_string,
+			sp.ignore_case)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --contains argument"))
+		}
+		var im *ip_matcher
+		var arg string
+		for _, arg = range contains_ip_in {
+			im, err = new_ip_in(arg)
+			if err != nil {
+				print_err(err)
+				usage_err(errors.New("invalid --contains-ip-in argument"))
+			}
+			sp.filter.contains = append(sp.filter.contains, im)
+		}
+		for _, arg = range contains_ip_equals {
+			im, err = new_ip_equals(arg)
+			if err != nil {
+				print_err(err)
+				usage_err(errors.New("invalid --contains-ip-equals argument"))
+			}
+			sp.filter.contains = append(sp.filter.contains, im)
+		}
+		sp.filter.not_contains, err = compile_patterns(not_contains,
+			sp.fixed_string, sp.ignore_case)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --not-contains argument"))
+		}
+	}
+	if sp.parent < 0 {
+		usage_err(errors.New("invalid --parent argument"))
+	}
+	if sp.following_siblings < 0 {
+		usage_err(errors.New("invalid --following-siblings argument"))
+	}
+	// at most one section algorithm variant selecting additional lines
+	n_var := 0
+	for _, sel := range []bool{sp.enclosing, sp.parent > 0, sp.siblings,
+		sp.following_siblings > 0} {
+		if sel {
+			n_var++
+		}
+	}
+	if n_var > 1 {
+		usage_err(errors.New("only one of --enclosing, --parent, " +
+			"--siblings, and --following-siblings can be used"))
+	}
+	if sp.top_level && n_var > 0 && !sp.enclosing {
+		usage_err(errors.New("--top-level cannot be used with --parent, " +
+			"--siblings, or --following-siblings"))
+	}
+	if max_buffer != "" {
+		sp.max_buffer, err = parse_size(max_buffer)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --max-buffer argument"))
+		}
+	}
+	if max_line_length != "" {
+		sp.max_line_length, err = parse_size(max_line_length)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --max-line-length argument"))
+		}
+	}
+	if enc, ok := encoding_names[strings.ToLower(encoding)]; ok {
+		sp.encoding = enc
 	} else {
-		sp.memory = new(memoryless_lm)
+		usage_err(errors.New("invalid --encoding argument"))
 	}
-	// also select section headers?
-	if sp.headers {
-		sp.memory.add_headers()
+	if unit, ok := indent_width_units[indent_width]; ok {
+		sp.indent_width = unit
+	} else {
+		usage_err(errors.New("invalid --indent-width argument"))
 	}
-	// already parameterized line printer as normal action
-	sp.memory.set_act(&lp)
-	// "ignore" line printer may be different from normal one
-	if sp.omit_ignored {
-		no_output := line_printer{
-			quiet: true,
+	if mode, ok := binary_files_modes[binary_files]; ok {
+		sp.binary_files = mode
+	} else {
+		usage_err(errors.New("invalid --binary-files argument"))
+	}
+	if text {
+		sp.binary_files = BINARY_FILES_TEXT
+	}
+	sp.binary_utf8 = utf8_locale()
+	if line_end, ok := line_endings_names[line_endings]; ok {
+		lp.line_end = line_end
+		sp.preserve_eol = line_end == ""
+	} else {
+		usage_err(errors.New("invalid --line-endings argument"))
+	}
+	if sort_sections.value != "" {
+		if order, ok := sort_orders[sort_sections.value]; ok {
+			sp.sort_order = order
+		} else {
+			usage_err(errors.New("invalid --sort-sections argument"))
+		}
+	}
+	if sort_key != "" {
+		sp.sort_key_re, err = regexp.Compile(sort_key)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --sort-key argument"))
 		}
-		sp.memory.set_ign(&no_output)
+	}
+	if sp.sort_depth < 0 {
+		usage_err(errors.New("invalid --sort-depth argument"))
+	}
+	if sp.flatten_sep == "" {
+		usage_err(errors.New("invalid --flatten-separator argument"))
+	}
+	if format, ok := input_formats[input]; ok {
+		sp.input_format = format
+	} else {
+		usage_err(errors.New("invalid --input argument"))
+	}
+	if format, ok := output_formats[output]; ok {
+		sp.output_format = format
 	} else {
-		sp.memory.set_ign(&lp)
+		usage_err(errors.New("invalid --output argument"))
 	}
-	// required pattern to match on is given as command line argument
-	if flag.NArg() < 1 {
-		usage_err(errors.New("PATTERN is missing"))
+	if sp.flatten && sp.output_format == OUTPUT_SET {
+		usage_err(errors.New("--flatten cannot be used with --output=set"))
 	}
-	pat_str := flag.Arg(0)
-	// escape meta characters if PATTERN is intended as a fixed string
-	if sp.fixed_string {
-		pat_str = regexp.QuoteMeta(pat_str)
+	if policy, ok := long_lines_policies[long_lines]; ok {
+		sp.long_lines = policy
+	} else {
+		usage_err(errors.New("invalid --long-lines argument"))
 	}
-	// adjust pattern according to command line flags
-	if sp.ignore_case {
-		pat_str = RE_IGN_CASE + pat_str
+	if jobs < 0 {
+		usage_err(errors.New("invalid --jobs argument"))
+	} else if jobs == 0 {
+		jobs = runtime.NumCPU()
 	}
-	sp.pat_re, err = regexp.Compile(pat_str)
-	if err != nil {
-		print_err(err)
-		usage_err(errors.New("invalid PATTERN"))
+	// merge files instead of selecting sections
+	if merge {
+		strategy, ok := merge_strategies[merge_strategy]
+		if !ok {
+			usage_err(errors.New("invalid --merge-strategy argument"))
+		}
+		if flag.NArg() < 2 {
+			usage_err(errors.New("--merge needs a BASE and an OVERLAY file"))
+		}
+		os.Exit(merge_files(sp, flag.Args(), strategy))
+	}
+	// templates replace the default line formatting
+	if format != "" || section_format != "" {
+		tf := new(template_formatter)
+		if format != "" {
+			tf.line_tmpl, err = template.New("format").Funcs(format_funcs).Parse(format)
+			if err != nil {
+				print_err(err)
+				usage_err(errors.New("invalid --format argument"))
+			}
+		}
+		if section_format != "" {
+			tf.section_tmpl, err = template.New("section-format").Funcs(format_funcs).Parse(section_format)
+			if err != nil {
+				print_err(err)
+				usage_err(errors.New("invalid --section-format argument"))
+			}
+		}
+		lp.formatter = tf
+	}
+	// tables replace the default line formatting, too
+	if table != "" {
+		comma, ok := table_formats[table]
+		if !ok {
+			usage_err(errors.New("invalid --table argument"))
+		}
+		if format != "" || section_format != "" || sp.output_format != OUTPUT_TEXT {
+			usage_err(errors.New("--table cannot be used with --format, " +
+				"--section-format, or --output"))
+		}
+		if len(columns) == 0 {
+			usage_err(errors.New("--table needs at least one --column"))
+		}
+		tf := &table_formatter{comma: comma}
+		var col string
+		for _, col = range columns {
+			name, re_str, found := strings.Cut(col, "=")
+			if !found || name == "" {
+				usage_err(errors.New("invalid --column argument"))
+			}
+			if sp.ignore_case {
+				re_str = RE_IGN_CASE + re_str
+			}
+			re, err := regexp.Compile(re_str)
+			if err != nil {
+				print_err(err)
+				usage_err(errors.New("invalid --column argument"))
+			}
+			tf.columns = append(tf.columns, table_column{name, re})
+		}
+		lp.formatter = tf
+		lp.separator = false
+		lp.file_separator = false
+		lp.file_header = false
+	} else if len(columns) > 0 {
+		usage_err(errors.New("--column needs --table"))
+	}
+	// documents replace the default line formatting, too
+	if sp.output_format == OUTPUT_HTML || sp.output_format == OUTPUT_MARKDOWN {
+		if format != "" || section_format != "" {
+			usage_err(errors.New(
+				"--format and --section-format cannot be used with --output=" +
+					output))
+		}
+		if sp.output_format == OUTPUT_HTML {
+			lp.formatter = &html_formatter{
+				limit: buffer_limit{max: sp.max_buffer}}
+		} else {
+			lp.formatter = &markdown_formatter{
+				limit: buffer_limit{max: sp.max_buffer}}
+		}
+		// the document structure replaces separator lines and file headers
+		lp.separator = false
+		lp.file_separator = false
+		lp.file_header = false
+	}
+	// already parameterized line printer as normal action
+	sp.memory = sp.new_memory(lp.begin, lp.omit, &lp)
+	sp.output = &lp
+	// a key path, an IP address, or an interface replaces the pattern
+	// argument
+	files := flag.Args()
+	n_sel := 0
+	for _, arg := range []string{key_path_str, ip_in, ip_equals, iface_str} {
+		if arg != "" {
+			n_sel++
+		}
+	}
+	if n_sel > 1 {
+		usage_err(errors.New("only one of --key-path, --ip-in, " +
+			"--ip-equals, and --interface can be used"))
+	}
+	// labels are taken from submatches of the pattern
+	if label_section != "" {
+		if n_sel > 0 {
+			usage_err(errors.New("--label-section cannot be used with " +
+				"--key-path, --ip-in, --ip-equals, or --interface"))
+		}
+		sp.label_tmpl = []byte(label_section)
+		lp.label_section = true
+	}
+	if key_path_str != "" {
+		sp.key_path, err = parse_key_path(key_path_str)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --key-path argument"))
+		}
+	} else if ip_in != "" {
+		sp.line_match, err = new_ip_in(ip_in)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --ip-in argument"))
+		}
+	} else if ip_equals != "" {
+		sp.line_match, err = new_ip_equals(ip_equals)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --ip-equals argument"))
+		}
+	} else if iface_str != "" {
+		sp.line_match, err = new_iface_matcher(iface_str)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid --interface argument"))
+		}
+	} else {
+		// required pattern to match on is given as command line argument
+		if flag.NArg() < 1 {
+			usage_err(errors.New("PATTERN is missing"))
+		}
+		pat_str := flag.Arg(0)
+		files = files[1:]
+		// escape meta characters if PATTERN is intended as a fixed string
+		if sp.fixed_string {
+			pat_str = regexp.QuoteMeta(pat_str)
+		}
+		// adjust pattern according to command line flags
+		if sp.ignore_case {
+			pat_str = RE_IGN_CASE + pat_str
+		}
+		sp.pat_re, err = regexp.Compile(pat_str)
+		if err != nil {
+			print_err(err)
+			usage_err(errors.New("invalid PATTERN"))
+		}
+	}
+
+	// following more than one file would require reading them concurrently
+	if follow && len(files) > 1 {
+		usage_err(errors.New("--follow supports at most one FILE"))
+	}
+	if idle_timeout < 0 {
+		usage_err(errors.New("invalid --idle-timeout argument"))
 	}
 
 	ec := 1
 	// operate on STDIN if no file name is provided,
 	// otherwise operate on the given files
-	if flag.NArg() == 1 {
+	if len(files) == 0 {
 		lp.filename = sp.stdin_label
-		m, err := section(sp, os.Stdin)
+		var r io.Reader = os.Stdin
+		if follow {
+			r = new_follow_reader(os.Stdin, "", idle_timeout)
+		}
+		m, err := section(sp, r)
 		ec = exit_code(ec, m, err)
+	} else if jobs > 1 && len(files) > 1 {
+		ec = section_files_parallel(sp, &lp, files, jobs, ec)
 	} else {
 		var m bool
 		var err error
 		var f *os.File
 		var arg string
-		for _, arg = range flag.Args()[1:] {
+		for _, arg = range files {
 			m = false
 			f, err = os.Open(arg)
 			if err != nil {
@@ -933,10 +6329,20 @@ func main() {
 			if lp.begin {
 				lp.select_rest = false
 			}
-			m, err = section(sp, f)
+			if follow {
+				m, err = section(sp, new_follow_reader(f, arg, idle_timeout))
+			} else {
+				m, err = section(sp, f)
+			}
 			ec = exit_code(ec, m, err)
 			f.Close()
 		}
 	}
+	// complete output documents
+	err = lp.close()
+	if err != nil {
+		print_err(err)
+		ec = 2
+	}
 	os.Exit(ec)
 }